@@ -0,0 +1,389 @@
+package auth
+
+import (
+	"bagel/internal/config"
+	"bagel/internal/logger"
+	"bagel/internal/random"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// How long a session of the web UI stays valid
+	sessionLifetime = config.Duration("BAGEL_SESSION_LIFETIME", 24*time.Hour)
+
+	// Used to compare against when a user does not exist, so the response time does not reveal valid usernames
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("bagel"), bcrypt.DefaultCost)
+
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUnauthenticated    = errors.New("authentication required")
+	ErrTokenNotFound      = errors.New("token not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrLastAdmin          = errors.New("the last admin can not be deleted")
+)
+
+const (
+	// Prefix of API tokens, makes them recognizable in logs and secret scanners
+	tokenPrefix = "bagel_"
+
+	// Minimum length of a password
+	minPasswordLength = 12
+)
+
+// User is an account that can log into the web UI and create API tokens
+type User struct {
+	ID           uuid.UUID `gorm:"type:text;primaryKey;"` // The UUID of the user
+	Username     string    `gorm:"type:text;uniqueIndex"` // The name used to log in
+	PasswordHash string    `gorm:"type:text"`             // The bcrypt hash of the password
+	IsAdmin      bool      `gorm:"type:boolean"`          // If the user can manage other users
+	CreatedAt    time.Time // The timestamp the user was created
+}
+
+// Session is a login of a user to the web UI, identified by a cookie
+type Session struct {
+	ID        uuid.UUID `gorm:"type:text;primaryKey;"` // The UUID of the session
+	TokenHash string    `gorm:"type:text;uniqueIndex"` // The SHA-256 hash of the cookie value
+	UserID    uuid.UUID `gorm:"type:text;index"`       // The user the session belongs to
+	CreatedAt time.Time // The timestamp the user logged in
+	ExpiresAt time.Time // The timestamp the session expires
+}
+
+// APIToken is a personal token of a user for scripted access to the API
+type APIToken struct {
+	ID         uuid.UUID `gorm:"type:text;primaryKey;"` // The UUID of the token
+	UserID     uuid.UUID `gorm:"type:text;index"`       // The user the token belongs to
+	Name       string    `gorm:"type:text"`             // The name of the token defined by the user
+	Hint       string    `gorm:"type:text"`             // The last characters of the token, used for the front end
+	TokenHash  string    `gorm:"type:text;uniqueIndex"` // The SHA-256 hash of the token
+	CreatedAt  time.Time // The timestamp the token was created
+	ExpiresAt  time.Time // The timestamp the token expires, zero if it never expires
+	LastUsedAt time.Time // The timestamp the token was last used
+	RevokedAt  time.Time // The timestamp the token was revoked, zero if it is still active
+}
+
+// IsActive checks if the token is neither revoked nor expired
+func (t *APIToken) IsActive() bool {
+	return t.RevokedAt.IsZero() && (t.ExpiresAt.IsZero() || time.Now().Before(t.ExpiresAt))
+}
+
+// Models returns the models of this package for the database migration
+func Models() []interface{} {
+	return []interface{}{&User{}, &Session{}, &APIToken{}}
+}
+
+// Init creates the initial admin account if there are no users yet. The credentials are taken from
+// BAGEL_ADMIN_USERNAME and BAGEL_ADMIN_PASSWORD, a random password is generated and printed if none is set
+func Init(db *gorm.DB) (err error) {
+	var count int64
+	if err := db.Model(&User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	username := config.String("BAGEL_ADMIN_USERNAME", "admin")
+	password := config.String("BAGEL_ADMIN_PASSWORD", "")
+	generated := password == ""
+	if generated {
+		if password, err = random.String(16); err != nil {
+			return err
+		}
+	}
+
+	if _, err := CreateUser(db, username, password, true); err != nil {
+		return fmt.Errorf("error creating initial admin: %s", err)
+	}
+
+	logger.Info("Created user '%s'", username)
+	if generated {
+		// Written once to stderr directly instead of through the logger, so it does not end up in the log lines
+		fmt.Fprintf(os.Stderr, "\nGenerated password of the user '%s': %s\nChange it after logging in, it is not shown again\n\n", username, password)
+	}
+
+	return nil
+}
+
+// CreateUser creates a new user with a bcrypt hash of the password
+func CreateUser(db *gorm.DB, username string, password string, isAdmin bool) (user *User, err error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username cannot be empty")
+	}
+
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := db.Model(&User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrUserExists
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user = &User{
+		ID:           random.UUID(),
+		Username:     username,
+		PasswordHash: string(hash),
+		IsAdmin:      isAdmin,
+		CreatedAt:    time.Now(),
+	}
+	if err := db.Create(user).Error; err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ListUsers returns all users ordered by name
+func ListUsers(db *gorm.DB) (users []User, err error) {
+	err = db.Order("username asc").Find(&users).Error
+	return users, err
+}
+
+// DeleteUser removes a user together with its sessions and tokens. The last admin can not be deleted
+func DeleteUser(db *gorm.DB, id uuid.UUID) (err error) {
+	var user User
+	result := db.Limit(1).Find(&user, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	if user.IsAdmin {
+		var admins int64
+		if err := db.Model(&User{}).Where("is_admin = ?", true).Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return ErrLastAdmin
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Session{}, "user_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&APIToken{}, "user_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&User{}, "id = ?", id).Error
+	})
+}
+
+// ChangePassword sets a new password after verifying the current one. All other sessions of the user are ended
+func ChangePassword(db *gorm.DB, user *User, current string, password string, keepSessionToken string) (err error) {
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
+		return errors.New("current password is wrong")
+	}
+
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_hash", string(hash)).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Session{}, "user_id = ? AND token_hash <> ?", user.ID, hashToken(keepSessionToken)).Error
+	})
+}
+
+// Login checks the credentials and creates a new session. Returns the secret to store in the cookie
+func Login(db *gorm.DB, username string, password string) (token string, session *Session, err error) {
+	var user User
+	result := db.Limit(1).Find(&user, "username = ?", strings.TrimSpace(username))
+	if result.Error != nil {
+		return "", nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		// Compare anyway so the timing is the same as for existing users
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	// Remove expired sessions while we are at it
+	if err := db.Delete(&Session{}, "expires_at < ?", time.Now()).Error; err != nil {
+		logger.ErrorF("error removing expired sessions: %s", err)
+	}
+
+	token, err = random.String(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	session = &Session{
+		ID:        random.UUID(),
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(sessionLifetime),
+	}
+	if err := db.Create(session).Error; err != nil {
+		return "", nil, err
+	}
+
+	logger.Info("User '%s' logged in", user.Username)
+	return token, session, nil
+}
+
+// Logout ends the session with the given cookie value
+func Logout(db *gorm.DB, token string) (err error) {
+	return db.Delete(&Session{}, "token_hash = ?", hashToken(token)).Error
+}
+
+// UserFromSession returns the user of a valid session cookie
+func UserFromSession(db *gorm.DB, token string) (user *User, err error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	var session Session
+	result := db.Limit(1).Find(&session, "token_hash = ? AND expires_at > ?", hashToken(token), time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrUnauthenticated
+	}
+
+	return findUser(db, session.UserID)
+}
+
+// CreateToken creates a new API token for the user. The token is only returned here, only its hash is stored
+func CreateToken(db *gorm.DB, user *User, name string, expiresAt time.Time) (token string, apiToken *APIToken, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.New("token name cannot be empty")
+	}
+
+	secret, err := random.String(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token = tokenPrefix + secret
+
+	apiToken = &APIToken{
+		ID:        random.UUID(),
+		UserID:    user.ID,
+		Name:      name,
+		Hint:      token[len(token)-4:],
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(apiToken).Error; err != nil {
+		return "", nil, err
+	}
+
+	logger.Info("User '%s' created API token '%s'", user.Username, name)
+	return token, apiToken, nil
+}
+
+// ListTokens returns all tokens of the user, newest first
+func ListTokens(db *gorm.DB, user *User) (tokens []APIToken, err error) {
+	err = db.Where("user_id = ?", user.ID).Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeToken revokes a token of the user
+func RevokeToken(db *gorm.DB, user *User, id uuid.UUID) (err error) {
+	result := db.Model(&APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at = ?", id, user.ID, time.Time{}).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+
+	logger.Info("User '%s' revoked API token %s", user.Username, id.String())
+	return nil
+}
+
+// UserFromToken returns the user of an active API token and records its usage
+func UserFromToken(db *gorm.DB, token string) (user *User, err error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrUnauthenticated
+	}
+
+	var apiToken APIToken
+	result := db.Limit(1).Find(&apiToken, "token_hash = ?", hashToken(token))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || !apiToken.IsActive() {
+		return nil, ErrUnauthenticated
+	}
+
+	if err := db.Model(&apiToken).Update("last_used_at", time.Now()).Error; err != nil {
+		logger.ErrorF("error updating API token %s: %s", apiToken.ID.String(), err)
+	}
+
+	return findUser(db, apiToken.UserID)
+}
+
+// findUser retrieves a user by ID
+func findUser(db *gorm.DB, id uuid.UUID) (user *User, err error) {
+	user = &User{}
+	result := db.Limit(1).Find(user, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrUnauthenticated
+	}
+
+	return user, nil
+}
+
+// validatePassword checks the password policy
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+
+	// bcrypt only uses the first 72 bytes
+	if len(password) > 72 {
+		return errors.New("password must be at most 72 bytes long")
+	}
+
+	return nil
+}
+
+// hashToken returns the SHA-256 hash of a session cookie or API token. Both are random with enough entropy
+// that a fast hash is sufficient, which allows looking them up by hash
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
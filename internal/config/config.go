@@ -0,0 +1,71 @@
+package config
+
+import (
+	"bagel/internal/logger"
+	"os"
+	"strconv"
+	"time"
+)
+
+// String returns the value of the ENV variable key or def if it is not set
+func String(key string, def string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return def
+	}
+
+	return value
+}
+
+// Int returns the value of the ENV variable key as an int or def if it is not set or invalid
+func Int(key string, def int) int {
+	return int(Int64(key, int64(def)))
+}
+
+// Int64 returns the value of the ENV variable key as an int64 or def if it is not set or invalid
+func Int64(key string, def int64) int64 {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return def
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || i < 0 {
+		logger.Warning("Invalid value '%s' for %s, using default %d", value, key, def)
+		return def
+	}
+
+	return i
+}
+
+// Bool returns the value of the ENV variable key as a bool or def if it is not set or invalid
+func Bool(key string, def bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warning("Invalid value '%s' for %s, using default %t", value, key, def)
+		return def
+	}
+
+	return b
+}
+
+// Duration returns the value of the ENV variable key as a time.Duration (like "30m") or def if it is not set or invalid
+func Duration(key string, def time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		logger.Warning("Invalid value '%s' for %s, using default %s", value, key, def)
+		return def
+	}
+
+	return d
+}
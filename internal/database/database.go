@@ -1,8 +1,11 @@
 package database
 
 import (
+	"bagel/internal/auth"
 	"bagel/internal/logger"
 	"bagel/internal/semgrep"
+	"bagel/internal/webhook"
+	"context"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
@@ -11,24 +14,95 @@ import (
 const (
 	// Hardcoded database name for now
 	dbName = "bagel.db"
+
+	// Wait for locks instead of failing, as multiple workers write to the database concurrently
+	dbPragmas = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
 )
 
 // Init sets up the database connection
 func Init() (db *gorm.DB, err error) {
-	db, err = gorm.Open(sqlite.Open(dbName), &gorm.Config{})
+	db, err = gorm.Open(sqlite.Open(dbName+dbPragmas), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 	logger.Info("Connected to database %s", dbName)
 
-	if err := db.AutoMigrate(&semgrep.Scan{}); err != nil {
+	// Findings of existing scans are only created once, when the table is created
+	backfillFindings := !db.Migrator().HasTable(&semgrep.Finding{})
+
+	if err := db.AutoMigrate(append([]interface{}{&semgrep.Scan{}, &semgrep.Finding{}, &semgrep.CustomRuleset{}, &semgrep.Project{}}, append(auth.Models(), webhook.Models()...)...)...); err != nil {
+		return nil, err
+	}
+	if err := migrateFinished(db); err != nil {
 		return nil, err
 	}
+	if err := migrateRuleset(db); err != nil {
+		return nil, err
+	}
+	if backfillFindings {
+		if err := semgrep.BackfillFindings(db); err != nil {
+			return nil, err
+		}
+	}
 	logger.Info("Migrated database")
 
 	return db, nil
 }
 
+// migrateFinished converts the finished column of databases created before scans had a status.
+// Unfinished scans are marked as running so they are recovered when the workers start
+func migrateFinished(db *gorm.DB) (err error) {
+	if !db.Migrator().HasColumn(&semgrep.Scan{}, "finished") {
+		return nil
+	}
+
+	err = db.Exec(
+		"UPDATE scans SET status = CASE WHEN finished THEN (CASE WHEN error <> '' THEN ? ELSE ? END) ELSE ? END WHERE status IS NULL OR status = ''",
+		semgrep.StatusFailed, semgrep.StatusDone, semgrep.StatusRunning,
+	).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Migrator().DropColumn(&semgrep.Scan{}, "finished")
+}
+
+// migrateRuleset converts the single ruleset of databases created before scans had multiple rulesets
+func migrateRuleset(db *gorm.DB) (err error) {
+	if !db.Migrator().HasColumn(&semgrep.Scan{}, "ruleset_name") {
+		return nil
+	}
+
+	// Only databases with custom rulesets have the custom column
+	custom := "'false'"
+	if db.Migrator().HasColumn(&semgrep.Scan{}, "ruleset_custom") {
+		custom = "CASE WHEN ruleset_custom THEN 'true' ELSE 'false' END"
+	}
+
+	err = db.Exec(
+		"UPDATE scans SET rulesets = json_array(json_object('name', ruleset_name, 'custom', json(" + custom + "))) WHERE rulesets IS NULL OR rulesets = ''",
+	).Error
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []string{"ruleset_name", "ruleset_custom"} {
+		if db.Migrator().HasColumn(&semgrep.Scan{}, column) {
+			if err := db.Migrator().DropColumn(&semgrep.Scan{}, column); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Ping checks if the database can be queried, it gives up once ctx is done
+func Ping(ctx context.Context, db *gorm.DB) (err error) {
+	var one int
+	return db.WithContext(ctx).Raw("SELECT 1").Scan(&one).Error
+}
+
 // Close closes the database connection
 func Close(db *gorm.DB) (err error) {
 	dbBagel, err := db.DB()
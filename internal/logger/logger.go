@@ -1,60 +1,235 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"strings"
 
 	"github.com/fatih/color"
 )
 
+const (
+	// The level of fatal errors, above errors
+	levelFatal = slog.LevelError + 4
+)
+
 var (
+	// The format of the log lines from BAGEL_LOG_FORMAT, text or json.
+	// Read from the environment directly, as the config package logs invalid values itself
+	format = strings.ToLower(os.Getenv("BAGEL_LOG_FORMAT"))
+
+	// The minimum level of the log lines from BAGEL_LOG_LEVEL, debug, info, warn or error
+	level = new(slog.LevelVar)
+
+	// The logger all log lines go through
+	root = &Logger{l: slog.New(newHandler(format, color.Error, os.Stderr))}
+
+	styleDebug   = "[" + color.CyanString("DBG") + "]"
 	styleInfo    = "[" + color.BlueString("INF") + "]"
 	styleWarning = "[" + color.YellowString("WAR") + "]"
 	styleError   = "[" + color.RedString("ERR") + "]"
 	styleFatal   = "[" + color.RedString("FTL") + "]"
 )
 
+// Logger writes log lines with attributes attached to each of them, like the scan ID in the workers
+type Logger struct {
+	l *slog.Logger
+}
+
+func init() {
+	value := os.Getenv("BAGEL_LOG_LEVEL")
+	if strings.EqualFold(value, "warning") {
+		value = "warn"
+	}
+	if value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			Warning("Invalid value %q for BAGEL_LOG_LEVEL, using info", value)
+		}
+	}
+
+	if format != "" && format != "text" && format != "json" {
+		Warning("Invalid value %q for BAGEL_LOG_FORMAT, using text", format)
+	}
+}
+
+// newHandler returns the handler for the format, JSON lines for json and colored text otherwise
+func newHandler(format string, text io.Writer, json io.Writer) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(json, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.LevelKey && len(groups) == 0 && a.Value.Any() == levelFatal {
+					a.Value = slog.StringValue("FATAL")
+				}
+				return a
+			},
+		})
+	}
+
+	return &textHandler{w: text}
+}
+
+// With returns a logger adding the attributes, given as alternating keys and values, to every log line
+func With(args ...any) *Logger {
+	return root.With(args...)
+}
+
+// With returns a logger adding the attributes, given as alternating keys and values, to every log line
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{l: l.l.With(args...)}
+}
+
+// Debug prints a debug format string, only shown with BAGEL_LOG_LEVEL=debug
+func Debug(s string, args ...interface{}) {
+	root.Debug(s, args...)
+}
+
 // Info prints a info format string to stderr
 func Info(s string, args ...interface{}) {
-	msg := fmt.Sprintf(s, args...)
-	msg = fmt.Sprintf("%s %s", styleInfo, msg)
-
-	fmt.Fprint(color.Error, formatMsg(msg))
+	root.Info(s, args...)
 }
 
 // Warning prints a warning format string to stderr
 func Warning(s string, args ...interface{}) {
-	msg := fmt.Sprintf(s, args...)
-	msg = fmt.Sprintf("%s %s", styleWarning, msg)
-
-	fmt.Fprint(color.Error, formatMsg(msg))
+	root.Warning(s, args...)
 }
 
 // Error prints an error to stderr
 func Error(err error) {
-	msg := fmt.Sprintf("%s %s", styleError, err)
-	fmt.Fprint(color.Error, formatMsg(msg))
+	root.Error(err)
 }
 
 // ErrorF prints an error format string to stderr
 func ErrorF(s string, args ...interface{}) {
-	msg := fmt.Sprintf(s, args...)
-	msg = fmt.Sprintf("%s %s", styleError, msg)
-	fmt.Fprint(color.Error, formatMsg(msg))
+	root.ErrorF(s, args...)
 }
 
 // Fatal prints an fatal error to stderr and quit
 func Fatal(err error) {
-	msg := fmt.Sprintf("%s %s", styleFatal, err)
-	fmt.Fprint(color.Error, formatMsg(msg))
+	root.Fatal(err)
+}
+
+// Debug prints a debug format string, only shown with BAGEL_LOG_LEVEL=debug
+func (l *Logger) Debug(s string, args ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprintf(s, args...))
+}
+
+// Info prints a info format string to stderr
+func (l *Logger) Info(s string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(s, args...))
+}
+
+// Warning prints a warning format string to stderr
+func (l *Logger) Warning(s string, args ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprintf(s, args...))
+}
+
+// Error prints an error to stderr
+func (l *Logger) Error(err error) {
+	l.log(slog.LevelError, fmt.Sprint(err))
+}
+
+// ErrorF prints an error format string to stderr
+func (l *Logger) ErrorF(s string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(s, args...))
+}
+
+// Fatal prints an fatal error to stderr and quit
+func (l *Logger) Fatal(err error) {
+	l.log(levelFatal, fmt.Sprint(err))
 	os.Exit(1)
 }
 
-func formatMsg(s string) string {
-	if strings.HasSuffix(s, "\n") {
-		return s
-	} else {
-		return s + "\n"
+// log writes a message with the attributes of the logger
+func (l *Logger) log(lvl slog.Level, msg string) {
+	l.l.Log(context.Background(), lvl, strings.TrimSuffix(msg, "\n"))
+}
+
+// textHandler writes log lines as colored text with the level in front and the attributes as key=value after the message
+type textHandler struct {
+	w      io.Writer
+	attrs  string // The attributes added with WithAttrs, already formatted
+	prefix string // The groups added with WithGroup, prepended to the keys
+}
+
+// Enabled reports if the level is at least the configured minimum level
+func (h *textHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= level.Level()
+}
+
+// Handle writes a single log line
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+
+	switch {
+	case r.Level >= levelFatal:
+		b.WriteString(styleFatal)
+	case r.Level >= slog.LevelError:
+		b.WriteString(styleError)
+	case r.Level >= slog.LevelWarn:
+		b.WriteString(styleWarning)
+	case r.Level >= slog.LevelInfo:
+		b.WriteString(styleInfo)
+	default:
+		b.WriteString(styleDebug)
 	}
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.prefix, a)
+		return true
+	})
+	b.WriteByte('\n')
+
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// WithAttrs returns a handler adding the attributes to every log line
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		writeAttr(&b, h.prefix, a)
+	}
+
+	return &textHandler{w: h.w, attrs: b.String(), prefix: h.prefix}
+}
+
+// WithGroup returns a handler prefixing the keys of further attributes with the group
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &textHandler{w: h.w, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// writeAttr writes an attribute as key=value, values with spaces or quotes are quoted
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			writeAttr(b, prefix, ga)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+
+	fmt.Fprintf(b, " %s=%s", color.New(color.Faint).Sprint(prefix+a.Key), value)
 }
@@ -0,0 +1,260 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// The content type of the Prometheus text exposition format written by Write
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// All metrics by name, written in the order of their names
+	registry   = map[string]*desc{}
+	registryMu sync.Mutex
+
+	// Escapes label values and help texts of the text format
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// desc describes a metric and holds its series by label values
+type desc struct {
+	name   string
+	help   string
+	kind   string    // The Prometheus type: counter, gauge or histogram
+	labels []string  // The label names, every series has a value for each of them
+	bounds []float64 // The upper bounds of the buckets of histograms, in ascending order without +Inf
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a metric with one set of label values
+type series struct {
+	values  []string
+	value   float64  // The value of counters and gauges, the sum of histograms
+	buckets []uint64 // The cumulative counts of histograms, one per upper bound
+	count   uint64   // The number of observations of histograms
+	fn      func() float64
+}
+
+// Counter is a metric that only goes up, like the number of finished scans
+type Counter struct{ d *desc }
+
+// Gauge is a metric that goes up and down, like the number of busy workers
+type Gauge struct{ d *desc }
+
+// Histogram counts observations in buckets, like the durations of scans
+type Histogram struct{ d *desc }
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{d: register(name, help, "counter", labels)}
+}
+
+// NewGauge registers a gauge with the given label names
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{d: register(name, help, "gauge", labels)}
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is returned by fn whenever the metrics are written
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	d := register(name, help, "gauge", nil)
+	d.get(nil).fn = fn
+}
+
+// NewHistogram registers a histogram with the given upper bounds of its buckets and label names
+func NewHistogram(name string, help string, bounds []float64, labels ...string) *Histogram {
+	d := register(name, help, "histogram", labels)
+	d.bounds = slices.Clone(bounds)
+	slices.Sort(d.bounds)
+	return &Histogram{d: d}
+}
+
+// Inc increases the counter with the given label values by one
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increases the counter with the given label values, negative values are ignored
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.get(values).value += v
+}
+
+// Set sets the gauge with the given label values
+func (g *Gauge) Set(v float64, values ...string) {
+	g.d.mu.Lock()
+	defer g.d.mu.Unlock()
+	g.d.get(values).value = v
+}
+
+// Add adds to the gauge with the given label values, negative values decrease it
+func (g *Gauge) Add(v float64, values ...string) {
+	g.d.mu.Lock()
+	defer g.d.mu.Unlock()
+	g.d.get(values).value += v
+}
+
+// Observe adds an observation to the histogram with the given label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.d.mu.Lock()
+	defer h.d.mu.Unlock()
+
+	s := h.d.get(values)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.d.bounds))
+	}
+	for i, bound := range h.d.bounds {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// Write writes all metrics in the Prometheus text exposition format
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	descs := make([]*desc, 0, len(names))
+	for _, name := range names {
+		descs = append(descs, registry[name])
+	}
+	registryMu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, d := range descs {
+		d.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// register adds a metric to the registry. Registering a name twice is a programming error and panics
+func register(name string, help string, kind string, labels []string) *desc {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("metric registered twice: " + name)
+	}
+
+	d := &desc{name: name, help: help, kind: kind, labels: labels, series: map[string]*series{}}
+	registry[name] = d
+	return d
+}
+
+// get returns the series with the given label values, it is created if it does not exist yet.
+// The caller has to hold the lock of the metric
+func (d *desc) get(values []string) *series {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", d.name, len(d.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := d.series[key]
+	if !ok {
+		s = &series{values: slices.Clone(values)}
+		d.series[key] = s
+	}
+
+	return s
+}
+
+// write writes the metric with all of its series, ordered by their label values
+func (d *desc) write(w *bufio.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+
+	keys := make([]string, 0, len(d.series))
+	for key := range d.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := d.series[key]
+		labels := d.labelPairs(s.values)
+
+		switch {
+		case s.fn != nil:
+			writeSample(w, d.name, labels, s.fn())
+
+		case d.kind == "histogram":
+			for i, bound := range d.bounds {
+				le := append(slices.Clone(labels), "le", formatFloat(bound))
+				writeSample(w, d.name+"_bucket", le, float64(s.buckets[i]))
+			}
+			writeSample(w, d.name+"_bucket", append(slices.Clone(labels), "le", "+Inf"), float64(s.count))
+			writeSample(w, d.name+"_sum", labels, s.value)
+			writeSample(w, d.name+"_count", labels, float64(s.count))
+
+		default:
+			writeSample(w, d.name, labels, s.value)
+		}
+	}
+}
+
+// labelPairs returns the label names and values of a series as alternating names and values
+func (d *desc) labelPairs(values []string) (pairs []string) {
+	for i, name := range d.labels {
+		pairs = append(pairs, name, values[i])
+	}
+
+	return pairs
+}
+
+// writeSample writes a single line of the text format
+func writeSample(w *bufio.Writer, name string, pairs []string, v float64) {
+	w.WriteString(name)
+	if len(pairs) > 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(pairs); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats a value like Prometheus does
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
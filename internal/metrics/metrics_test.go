@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// written returns the text format of a single metric
+func written(t *testing.T, d *desc) string {
+	t.Helper()
+
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+	d.write(w)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+// TestCounter checks the HELP and TYPE lines and that the series are ordered by their label values
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "Number of things\nby status, in C:\\", "status")
+	c.Inc("failed")
+	c.Add(2, "done")
+	c.Add(-1, "done")
+
+	expected := `# HELP test_counter_total Number of things\nby status, in C:\\
+# TYPE test_counter_total counter
+test_counter_total{status="done"} 2
+test_counter_total{status="failed"} 1
+`
+	if got := written(t, c.d); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// TestGauge checks gauges with and without labels, including gauges with a function
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_gauge", "Number of workers by state", "state")
+	g.Set(4, "idle")
+	g.Add(-1, "idle")
+	g.Add(1, "busy")
+
+	expected := `# HELP test_gauge Number of workers by state
+# TYPE test_gauge gauge
+test_gauge{state="busy"} 1
+test_gauge{state="idle"} 3
+`
+	if got := written(t, g.d); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	NewGaugeFunc("test_gauge_func", "Depth of the queue", func() float64 { return 7 })
+
+	expected = `# HELP test_gauge_func Depth of the queue
+# TYPE test_gauge_func gauge
+test_gauge_func 7
+`
+	if got := written(t, registry["test_gauge_func"]); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// TestLabelEscaping checks that backslashes, quotes and newlines in label values are escaped
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounter("test_escaping_total", "Escaped labels", "path", "route")
+	c.Inc(`C:\src\app.py`, "say \"hi\"\nthere")
+
+	expected := `# HELP test_escaping_total Escaped labels
+# TYPE test_escaping_total counter
+test_escaping_total{path="C:\\src\\app.py",route="say \"hi\"\nthere"} 1
+`
+	if got := written(t, c.d); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// TestHistogram checks the cumulative buckets, the +Inf bucket, the sum and the count
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Duration of things", []float64{1, 0.5, 10}, "source")
+	for _, v := range []float64{0.25, 0.5, 3, 60} {
+		h.Observe(v, "git")
+	}
+
+	expected := `# HELP test_duration_seconds Duration of things
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{source="git",le="0.5"} 2
+test_duration_seconds_bucket{source="git",le="1"} 2
+test_duration_seconds_bucket{source="git",le="10"} 3
+test_duration_seconds_bucket{source="git",le="+Inf"} 4
+test_duration_seconds_sum{source="git"} 63.75
+test_duration_seconds_count{source="git"} 4
+`
+	if got := written(t, h.d); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// TestWrite checks that all metrics are written in the order of their names
+func TestWrite(t *testing.T) {
+	NewCounter("test_write_b_total", "Second").Inc()
+	NewCounter("test_write_a_total", "First").Inc()
+
+	var b bytes.Buffer
+	if err := Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	a := strings.Index(b.String(), "# HELP test_write_a_total First\n")
+	second := strings.Index(b.String(), "# HELP test_write_b_total Second\n")
+	if a < 0 || second < 0 || a > second {
+		t.Errorf("expected test_write_a_total before test_write_b_total, got:\n%s", b.String())
+	}
+}
+
+// TestRegisterTwice checks that registering a name twice panics
+func TestRegisterTwice(t *testing.T) {
+	NewCounter("test_twice_total", "Registered once")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic registering a metric twice")
+		}
+	}()
+	NewGauge("test_twice_total", "Registered twice")
+}
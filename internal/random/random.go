@@ -0,0 +1,29 @@
+package random
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+)
+
+// String returns n random bytes encoded as hex
+func String(n int) (s string, err error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// UUID generates a new random UUID
+// Do not use uuid.New() as it can panic
+func UUID() uuid.UUID {
+	for {
+		id, err := uuid.NewRandom()
+		if err == nil {
+			return id
+		}
+	}
+}
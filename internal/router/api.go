@@ -0,0 +1,396 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Default and maximum page size for paginated API responses
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// apiScan is the JSON representation of a scan returned by the API
+type apiScan struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	ProjectID   string     `json:"project_id,omitempty"`
+	Rulesets    []string   `json:"rulesets"`
+	Status      string     `json:"status"`
+	Stage       string     `json:"stage,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	UploadName  string     `json:"upload_name"`
+	GitURL      string     `json:"git_url,omitempty"`
+	GitRef      string     `json:"git_ref,omitempty"`
+	GitCommit   string     `json:"git_commit,omitempty"`
+	UploadDate  time.Time  `json:"upload_date"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	RequestID   string     `json:"request_id,omitempty"`
+	StatusURL   string     `json:"status_url"`
+	FindingsURL string     `json:"findings_url"`
+}
+
+// apiScanList is a page of scans returned by the API
+type apiScanList struct {
+	Scans   []apiScan `json:"scans"`
+	Page    int       `json:"page"`
+	PerPage int       `json:"per_page"`
+	Total   int64     `json:"total"`
+}
+
+// apiFinding is the JSON representation of a finding returned by the API
+type apiFinding struct {
+	ID          string          `json:"id"`
+	RuleID      string          `json:"rule_id"`
+	Path        string          `json:"path"`
+	StartLine   int             `json:"start_line"`
+	StartCol    int             `json:"start_col"`
+	EndLine     int             `json:"end_line"`
+	EndCol      int             `json:"end_col"`
+	Severity    string          `json:"severity"`
+	Message     string          `json:"message"`
+	Lines       string          `json:"lines"`
+	Metadata    json.RawMessage `json:"metadata"`
+	Fingerprint string          `json:"fingerprint"`
+	Ignored     bool            `json:"ignored"`
+	Rulesets    []string        `json:"rulesets"`
+	Triage      apiTriage       `json:"triage"`
+}
+
+// apiTriage is the decision of a reviewer for a finding
+type apiTriage struct {
+	Status    string     `json:"status"`
+	Comment   string     `json:"comment"`
+	TriagedBy string     `json:"triaged_by,omitempty"`
+	TriagedAt *time.Time `json:"triaged_at,omitempty"`
+	ScanID    string     `json:"scan_id,omitempty"`
+}
+
+// apiFindings is a page of findings of a scan returned by the API
+type apiFindings struct {
+	ScanID   string       `json:"scan_id"`
+	Findings []apiFinding `json:"findings"`
+	Page     int          `json:"page"`
+	PerPage  int          `json:"per_page"`
+	Total    int64        `json:"total"`
+}
+
+// apiErrorBody is the JSON body of every API error
+type apiErrorBody struct {
+	Error string `json:"error"`
+}
+
+// newAPIScan converts a scan into its JSON representation
+func newAPIScan(scan *semgrep.Scan) apiScan {
+	a := apiScan{
+		ID:          scan.ID.String(),
+		Name:        scan.ScanName,
+		Rulesets:    scan.Rulesets.Names(),
+		Status:      scan.Status,
+		Stage:       scan.Stage,
+		Error:       scan.Error,
+		UploadName:  scan.UploadName,
+		GitURL:      scan.GitURL,
+		GitRef:      scan.GitRef,
+		GitCommit:   scan.GitCommit,
+		UploadDate:  scan.UploadDate,
+		RequestID:   scan.RequestID,
+		StatusURL:   "/api/v1/scans/" + scan.ID.String(),
+		FindingsURL: "/api/v1/scans/" + scan.ID.String() + "/findings",
+	}
+
+	if scan.ProjectID != uuid.Nil {
+		a.ProjectID = scan.ProjectID.String()
+	}
+
+	// Leave out timestamps that are not set yet
+	if !scan.StartedAt.IsZero() {
+		a.StartedAt = &scan.StartedAt
+	}
+	if !scan.FinishedAt.IsZero() {
+		a.FinishedAt = &scan.FinishedAt
+	}
+
+	return a
+}
+
+// newAPIFinding converts a finding into its JSON representation
+func newAPIFinding(f *semgrep.Finding) apiFinding {
+	rulesets := f.Rulesets
+	if rulesets == nil {
+		rulesets = []string{}
+	}
+
+	triage := apiTriage{Status: f.TriageStatus, Comment: f.TriageComment, TriagedBy: f.TriagedBy}
+	if !f.TriagedAt.IsZero() {
+		triage.TriagedAt = &f.TriagedAt
+	}
+	if f.TriageScanID != uuid.Nil {
+		triage.ScanID = f.TriageScanID.String()
+	}
+
+	return apiFinding{
+		ID:          f.ID.String(),
+		RuleID:      f.RuleID,
+		Path:        f.Path,
+		StartLine:   f.StartLine,
+		StartCol:    f.StartCol,
+		EndLine:     f.EndLine,
+		EndCol:      f.EndCol,
+		Severity:    f.Severity,
+		Message:     f.Message,
+		Lines:       f.Lines,
+		Metadata:    json.RawMessage(f.Metadata),
+		Fingerprint: f.Fingerprint,
+		Ignored:     f.Ignored,
+		Rulesets:    rulesets,
+		Triage:      triage,
+	}
+}
+
+// newAPIFindings converts findings into their JSON representation
+func newAPIFindings(findings []semgrep.Finding) []apiFinding {
+	list := []apiFinding{}
+	for i := range findings {
+		list = append(list, newAPIFinding(&findings[i]))
+	}
+
+	return list
+}
+
+// pagination reads the query parameters page and per_page, the error is already sent if they are invalid
+func pagination(c *gin.Context) (page int, perPage int, ok bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		apiError(c, http.StatusBadRequest, errors.New("page must be a positive integer"))
+		return 0, 0, false
+	}
+
+	perPage, err = strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPerPage)))
+	if err != nil || perPage < 1 || perPage > maxPerPage {
+		apiError(c, http.StatusBadRequest, errors.New("per_page must be between 1 and "+strconv.Itoa(maxPerPage)))
+		return 0, 0, false
+	}
+
+	return page, perPage, true
+}
+
+// apiError aborts the request with a JSON error body
+func apiError(c *gin.Context, status int, err error) {
+	// Server errors are logged by ErrorHandler, as the body is not logged
+	if status >= http.StatusInternalServerError {
+		_ = c.Error(err)
+	}
+	c.AbortWithStatusJSON(status, apiErrorBody{Error: err.Error()})
+}
+
+// findScan retrieves the scan with the ID from the path parameter, the error is already sent if it fails
+func findScan(c *gin.Context) (scan *semgrep.Scan, ok bool) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	scan = &semgrep.Scan{}
+	result := db.Limit(1).Find(scan, "id = ?", id)
+	if result.Error != nil {
+		apiError(c, http.StatusInternalServerError, result.Error)
+		return nil, false
+	}
+	if result.RowsAffected == 0 {
+		apiError(c, http.StatusNotFound, semgrep.ErrScanNotFound)
+		return nil, false
+	}
+
+	return scan, true
+}
+
+// apiNewScan creates a new scan from a multipart form, see newScan for the fields
+func apiNewScan(c *gin.Context) {
+	scan, status, err := createScan(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.Header("Location", "/api/v1/scans/"+scan.ID.String())
+	c.JSON(http.StatusAccepted, newAPIScan(scan))
+}
+
+// apiListScans lists the scans, newest first. Supports the query parameters page and per_page
+// for pagination as well as status, ruleset and name (substring match) as filters
+func apiListScans(c *gin.Context) {
+	page, perPage, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	query := db.Model(&semgrep.Scan{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if ruleset := c.Query("ruleset"); ruleset != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(scans.rulesets) WHERE json_extract(value, '$.name') = ?)", ruleset)
+	}
+	if project := c.Query("project"); project != "" {
+		query = query.Where("project_id = ?", project)
+	}
+	if name := c.Query("name"); name != "" {
+		// Names are stored sanitized, so the filter has to be sanitized the same way to match them
+		query = query.Where("scan_name LIKE ? ESCAPE '\\'", "%"+escapeLike(SanitizeHTML(name))+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var scans []semgrep.Scan
+	if err := query.Order("upload_date desc").Offset((page - 1) * perPage).Limit(perPage).Find(&scans).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := apiScanList{Scans: []apiScan{}, Page: page, PerPage: perPage, Total: total}
+	for i := range scans {
+		list.Scans = append(list.Scans, newAPIScan(&scans[i]))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// apiGetScan returns the status of a scan
+func apiGetScan(c *gin.Context) {
+	scan, ok := findScan(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, newAPIScan(scan))
+}
+
+// apiGetFindings returns the findings of a finished scan ordered by their location. Supports the query parameters
+// page and per_page for pagination as well as severity, rule_id, ruleset, triage and path (prefix match) as filters
+func apiGetFindings(c *gin.Context) {
+	scan, ok := findScan(c)
+	if !ok {
+		return
+	}
+
+	if scan.Status != semgrep.StatusDone {
+		apiError(c, http.StatusConflict, errors.New("scan is "+scan.Status+", findings are only available for scans that are done"))
+		return
+	}
+
+	page, perPage, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	query := db.Model(&semgrep.Finding{}).Where("scan_id = ?", scan.ID)
+	if severity := c.Query("severity"); severity != "" {
+		query = query.Where("severity = ?", strings.ToUpper(severity))
+	}
+	if ruleID := c.Query("rule_id"); ruleID != "" {
+		query = query.Where("rule_id = ?", ruleID)
+	}
+	if ruleset := c.Query("ruleset"); ruleset != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(findings.rulesets) WHERE value = ?)", ruleset)
+	}
+	if triage := c.Query("triage"); triage != "" {
+		query = query.Where("triage_status = ?", triage)
+	}
+	if path := c.Query("path"); path != "" {
+		query = query.Where("path LIKE ? ESCAPE '\\'", escapeLike(path)+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var findings []semgrep.Finding
+	if err := query.Order("path, start_line, start_col, rule_id").Offset((page - 1) * perPage).Limit(perPage).Find(&findings).Error; err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := apiFindings{ScanID: scan.ID.String(), Findings: newAPIFindings(findings), Page: page, PerPage: perPage, Total: total}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+// apiDeleteScan cancels the scan if it is not finished and removes it from the database
+func apiDeleteScan(c *gin.Context) {
+	scan, ok := findScan(c)
+	if !ok {
+		return
+	}
+
+	if err := semgrep.Cancel(db, scan.ID); err != nil && !errors.Is(err, semgrep.ErrScanFinished) && !errors.Is(err, semgrep.ErrScanNotFound) {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := semgrep.DeleteScan(db, scan.ID); err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// apiCancelScan cancels a queued or running scan
+func apiCancelScan(c *gin.Context) {
+	scan, ok := findScan(c)
+	if !ok {
+		return
+	}
+
+	if err := semgrep.Cancel(db, scan.ID); err != nil {
+		switch {
+		case errors.Is(err, semgrep.ErrScanNotFound):
+			apiError(c, http.StatusNotFound, err)
+		case errors.Is(err, semgrep.ErrScanFinished):
+			apiError(c, http.StatusConflict, err)
+		default:
+			apiError(c, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// Reload the scan as a queued scan is cancelled immediately
+	db.Limit(1).Find(scan, "id = ?", scan.ID)
+
+	c.JSON(http.StatusAccepted, newAPIScan(scan))
+}
+
+// noRoute returns a JSON error for unknown API routes and serves the static files otherwise
+func noRoute(serveStatic gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			apiError(c, http.StatusNotFound, errors.New("route not found"))
+			return
+		}
+
+		serveStatic(c)
+	}
+}
@@ -0,0 +1,318 @@
+package router
+
+import (
+	"bagel/internal/auth"
+	"bagel/internal/config"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// Set the Secure flag on the session cookie, enable when Bagel is served via HTTPS behind a proxy
+	secureCookies = config.Bool("BAGEL_SECURE_COOKIES", false)
+
+	// Routes that can be accessed without logging in, by method and Gin path
+	publicRoutes = map[string]bool{}
+)
+
+const (
+	// Name of the session cookie of the web UI
+	sessionCookie = "bagel_session"
+
+	// Key of the logged in user in the Gin context
+	contextUser = "user"
+)
+
+// apiToken is the JSON representation of an API token returned by the API
+type apiToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Token      string     `json:"token,omitempty"` // Only set once when the token is created
+}
+
+// newAPIToken converts an API token into its JSON representation
+func newAPIToken(t *auth.APIToken) apiToken {
+	a := apiToken{
+		ID:        t.ID.String(),
+		Name:      t.Name,
+		Hint:      t.Hint,
+		Active:    t.IsActive(),
+		CreatedAt: t.CreatedAt,
+	}
+
+	// Leave out timestamps that are not set
+	if !t.ExpiresAt.IsZero() {
+		a.ExpiresAt = &t.ExpiresAt
+	}
+	if !t.LastUsedAt.IsZero() {
+		a.LastUsedAt = &t.LastUsedAt
+	}
+	if !t.RevokedAt.IsZero() {
+		a.RevokedAt = &t.RevokedAt
+	}
+
+	return a
+}
+
+// currentUser returns the logged in user of the request, nil for public routes
+func currentUser(c *gin.Context) *auth.User {
+	if user, ok := c.Get(contextUser); ok {
+		return user.(*auth.User)
+	}
+
+	return nil
+}
+
+// render renders a template and adds the logged in user for the header
+func render(c *gin.Context, code int, name string, data gin.H) {
+	data["User"] = currentUser(c)
+	c.HTML(code, name, data)
+}
+
+// isAPI checks if the request is for the JSON API
+func isAPI(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/api/")
+}
+
+// showLogin displays the login form
+func showLogin(c *gin.Context) {
+	render(c, http.StatusOK, "login.tmpl", gin.H{"Title": "Login", "Next": safeNext(c.Query("next"))})
+}
+
+// login checks the credentials of the login form and sets the session cookie
+func login(c *gin.Context) {
+	next := safeNext(c.PostForm("next"))
+
+	token, session, err := auth.Login(db, c.PostForm("username"), c.PostForm("password"))
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidCredentials) {
+			_ = c.Error(err)
+		}
+		render(c, http.StatusUnauthorized, "login.tmpl", gin.H{"Title": "Login", "Next": next, "Error": auth.ErrInvalidCredentials.Error()})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, token, int(time.Until(session.ExpiresAt).Seconds()), "/", "", secureCookies || c.Request.TLS != nil, true)
+
+	c.Redirect(http.StatusFound, next)
+}
+
+// logout ends the session and removes the cookie
+func logout(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		if err := auth.Logout(db, token); err != nil {
+			_ = c.Error(err)
+		}
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, "", -1, "/", "", secureCookies || c.Request.TLS != nil, true)
+
+	c.Redirect(http.StatusFound, "/login")
+}
+
+// safeNext only allows local paths as the redirect after logging in
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	return next
+}
+
+// showAccount displays the account page with the API tokens and, for admins, the users
+func showAccount(c *gin.Context) {
+	renderAccount(c, http.StatusOK, gin.H{})
+}
+
+// renderAccount renders the account page with additional data like a newly created token
+func renderAccount(c *gin.Context, code int, data gin.H) {
+	user := currentUser(c)
+
+	tokens, err := auth.ListTokens(db, user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+	data["Tokens"] = tokens
+
+	if user.IsAdmin {
+		users, err := auth.ListUsers(db)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "%s", err)
+			return
+		}
+		data["Users"] = users
+	}
+
+	data["Title"] = "Account"
+	render(c, code, "account.tmpl", data)
+}
+
+// changePassword changes the password of the logged in user
+func changePassword(c *gin.Context) {
+	token, _ := c.Cookie(sessionCookie)
+	if err := auth.ChangePassword(db, currentUser(c), c.PostForm("current"), c.PostForm("password"), token); err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/account")
+}
+
+// createToken creates a new API token and shows it once on the account page
+func createToken(c *gin.Context) {
+	token, _, status, err := newToken(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	renderAccount(c, http.StatusCreated, gin.H{"NewToken": token})
+}
+
+// revokeToken revokes an API token of the logged in user
+func revokeToken(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if err := auth.RevokeToken(db, currentUser(c), uuid.MustParse(id)); err != nil {
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			c.String(http.StatusNotFound, "Token not found")
+			return
+		}
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/account")
+}
+
+// createUser creates a new user, only allowed for admins
+func createUser(c *gin.Context) {
+	if !currentUser(c).IsAdmin {
+		c.String(http.StatusForbidden, "Only admins can create users")
+		return
+	}
+
+	_, err := auth.CreateUser(db, c.PostForm("username"), c.PostForm("password"), c.PostForm("admin") == "on")
+	if err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/account")
+}
+
+// deleteUser deletes a user, only allowed for admins
+func deleteUser(c *gin.Context) {
+	if !currentUser(c).IsAdmin {
+		c.String(http.StatusForbidden, "Only admins can delete users")
+		return
+	}
+
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if err := auth.DeleteUser(db, uuid.MustParse(id)); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			c.String(http.StatusNotFound, "User not found")
+		case errors.Is(err, auth.ErrLastAdmin):
+			c.String(http.StatusConflict, "%s", err)
+		default:
+			c.String(http.StatusInternalServerError, "%s", err)
+		}
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/account")
+}
+
+// newToken creates an API token from the fields name and the optional expires_in_days.
+// Returns the HTTP status code to use together with the error if it fails
+func newToken(c *gin.Context) (token string, apiToken *auth.APIToken, status int, err error) {
+	var expiresAt time.Time
+	if days := c.PostForm("expires_in_days"); days != "" {
+		d, err := strconv.Atoi(days)
+		if err != nil || d < 1 {
+			return "", nil, http.StatusBadRequest, errors.New("expires_in_days must be a positive integer")
+		}
+		expiresAt = time.Now().AddDate(0, 0, d)
+	}
+
+	token, apiToken, err = auth.CreateToken(db, currentUser(c), c.PostForm("name"), expiresAt)
+	if err != nil {
+		return "", nil, http.StatusBadRequest, err
+	}
+
+	return token, apiToken, http.StatusCreated, nil
+}
+
+// apiListTokens lists the API tokens of the authenticated user
+func apiListTokens(c *gin.Context) {
+	tokens, err := auth.ListTokens(db, currentUser(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := []apiToken{}
+	for i := range tokens {
+		list = append(list, newAPIToken(&tokens[i]))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// apiCreateToken creates a new API token for the authenticated user, the token is only returned once
+func apiCreateToken(c *gin.Context) {
+	token, t, status, err := newToken(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	a := newAPIToken(t)
+	a.Token = token
+	c.JSON(http.StatusCreated, a)
+}
+
+// apiRevokeToken revokes an API token of the authenticated user
+func apiRevokeToken(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := auth.RevokeToken(db, currentUser(c), uuid.MustParse(id)); err != nil {
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			apiError(c, http.StatusNotFound, err)
+			return
+		}
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Number of earlier scans offered as the base of a comparison
+	maxDiffBaseScans = 50
+)
+
+// apiDiff is the comparison of two scans returned by the API
+type apiDiff struct {
+	BaseScanID string       `json:"base_scan_id"`
+	HeadScanID string       `json:"head_scan_id"`
+	New        []apiFinding `json:"new"`
+	Resolved   []apiFinding `json:"resolved"`
+	Persisting []apiFinding `json:"persisting"`
+}
+
+// diffScans compares the scan from the id parameter with the scan from the base query parameter, or with the
+// previous scan of the same codebase if it is not set. Returns the HTTP status code to use together with the error if it fails
+func diffScans(c *gin.Context) (diff *semgrep.ScanDiff, status int, err error) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	headID := uuid.MustParse(id)
+
+	var baseID uuid.UUID
+	if base := c.Query("base"); base != "" {
+		if err := validateID(base); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		baseID = uuid.MustParse(base)
+	} else {
+		head := &semgrep.Scan{}
+		result := db.Omit("semgrep_output").Limit(1).Find(head, "id = ?", headID)
+		if result.Error != nil {
+			return nil, http.StatusInternalServerError, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, http.StatusNotFound, semgrep.ErrScanNotFound
+		}
+
+		previous, err := head.PreviousScan(db)
+		if err != nil {
+			if errors.Is(err, semgrep.ErrScanNotFound) {
+				return nil, http.StatusNotFound, errors.New("no earlier scan of the same codebase is done")
+			}
+			return nil, http.StatusInternalServerError, err
+		}
+		baseID = previous.ID
+	}
+
+	diff, err = semgrep.DiffScans(db, baseID, headID)
+	if err != nil {
+		switch {
+		case errors.Is(err, semgrep.ErrScanNotFound):
+			return nil, http.StatusNotFound, err
+		case errors.Is(err, semgrep.ErrScanNotDone):
+			return nil, http.StatusConflict, err
+		default:
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	return diff, http.StatusOK, nil
+}
+
+// getScanDiff displays the findings a scan introduced and resolved compared to an earlier scan
+func getScanDiff(c *gin.Context) {
+	diff, status, err := diffScans(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	// Offer the other earlier scans of the same codebase as the base
+	earlier, err := diff.Head.EarlierScans(db, maxDiffBaseScans)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	render(c, http.StatusOK, "diff.tmpl", gin.H{"Title": "Compare " + diff.Head.ScanName, "Diff": diff, "EarlierScans": earlier})
+}
+
+// apiGetScanDiff returns the findings a scan introduced and resolved compared to an earlier scan
+func apiGetScanDiff(c *gin.Context) {
+	diff, status, err := diffScans(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, apiDiff{
+		BaseScanID: diff.Base.ID.String(),
+		HeadScanID: diff.Head.ID.String(),
+		New:        newAPIFindings(diff.New),
+		Resolved:   newAPIFindings(diff.Resolved),
+		Persisting: newAPIFindings(diff.Persisting),
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package router
+
+import "errors"
+
+// freeBytes is only supported on Linux and macOS, as the fields of statfs differ between the other platforms
+func freeBytes(path string) (free uint64, err error) {
+	return 0, errors.ErrUnsupported
+}
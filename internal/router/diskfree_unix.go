@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package router
+
+import "syscall"
+
+// freeBytes returns the number of bytes available to unprivileged users on the file system of the path
+func freeBytes(path string) (free uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil // #nosec G115 - the block size is positive
+}
@@ -0,0 +1,155 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// How often a comment is sent on idle event streams, so proxies do not close them
+	eventKeepAlive = 15 * time.Second
+
+	// How long browsers wait before reconnecting to a closed event stream, in milliseconds
+	eventRetry = 5000
+)
+
+var (
+	// Closed when the server shuts down, as the graceful shutdown waits for event streams to end
+	chanStopStreams = make(chan struct{})
+)
+
+// streamEvents sends the status transitions of the scan with the given ID, or of all scans for uuid.Nil,
+// as server-sent events. The stream of a single scan ends once the scan is finished
+func streamEvents(c *gin.Context, scanID uuid.UUID, sendError func(status int, err error)) {
+	// Subscribe before loading the current state, so no transition in between is missed
+	events, unsubscribe := semgrep.SubscribeEvents(scanID)
+	defer unsubscribe()
+
+	var scans []semgrep.Scan
+	query := db.Omit("semgrep_output")
+	if scanID != uuid.Nil {
+		query = query.Where("id = ?", scanID)
+	} else {
+		query = query.Where("status IN ?", []string{semgrep.StatusQueued, semgrep.StatusRunning}).Order("upload_date asc")
+	}
+	if err := query.Find(&scans).Error; err != nil {
+		sendError(http.StatusInternalServerError, err)
+		return
+	}
+	if scanID != uuid.Nil && len(scans) == 0 {
+		sendError(http.StatusNotFound, semgrep.ErrScanNotFound)
+		return
+	}
+
+	// The stream lives longer than the write timeout of the server
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		sendError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if _, err := fmt.Fprintf(c.Writer, "retry: %d\n\n", eventRetry); err != nil {
+		return
+	}
+
+	for i := range scans {
+		event := scans[i].Event()
+		if err := writeEvent(c, &event); err != nil || (scanID != uuid.Nil && event.Finished()) {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+
+		case <-chanStopStreams:
+			return
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+		case event := <-events:
+			if err := writeEvent(c, &event); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			if scanID != uuid.Nil && event.Finished() {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a scan event as a server-sent event with the event as JSON in its data
+func writeEvent(c *gin.Context, event *semgrep.ScanEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(c.Writer, "data: %s\n\n", b)
+	return err
+}
+
+// scanEventsID returns the scan ID from the id parameter, the error is sent with sendError if it is invalid
+func scanEventsID(c *gin.Context, sendError func(status int, err error)) (id uuid.UUID, ok bool) {
+	if err := validateID(c.Param("id")); err != nil {
+		sendError(http.StatusBadRequest, err)
+		return uuid.Nil, false
+	}
+
+	return uuid.MustParse(c.Param("id")), true
+}
+
+// getScanEvents streams the status transitions of a scan until it is finished
+func getScanEvents(c *gin.Context) {
+	sendError := func(status int, err error) { c.String(status, "%s", err) }
+
+	if id, ok := scanEventsID(c, sendError); ok {
+		streamEvents(c, id, sendError)
+	}
+}
+
+// getEvents streams the status transitions of all scans, starting with the scans that are not finished
+func getEvents(c *gin.Context) {
+	streamEvents(c, uuid.Nil, func(status int, err error) { c.String(status, "%s", err) })
+}
+
+// apiGetScanEvents streams the status transitions of a scan until it is finished
+func apiGetScanEvents(c *gin.Context) {
+	sendError := func(status int, err error) { apiError(c, status, err) }
+
+	if id, ok := scanEventsID(c, sendError); ok {
+		streamEvents(c, id, sendError)
+	}
+}
+
+// apiGetEvents streams the status transitions of all scans, starting with the scans that are not finished
+func apiGetEvents(c *gin.Context) {
+	streamEvents(c, uuid.Nil, func(status int, err error) { apiError(c, status, err) })
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	csvContentType  = "text/csv; charset=utf-8"
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// exportScan loads the scan from the id parameter together with its findings for an export.
+// Returns the HTTP status code to use together with the error if it fails
+func exportScan(c *gin.Context) (scan *semgrep.Scan, status int, err error) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	scan = &semgrep.Scan{}
+	result := db.Limit(1).Find(scan, "id = ?", id)
+	if result.Error != nil {
+		return nil, http.StatusInternalServerError, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, http.StatusNotFound, semgrep.ErrScanNotFound
+	}
+	if scan.Status != semgrep.StatusDone {
+		return nil, http.StatusConflict, errors.New("scan is " + scan.Status + ", exports are only available for scans that are done")
+	}
+
+	// Large scans take longer to load and export than the write timeout of the server
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if err := scan.LoadFindings(db); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return scan, http.StatusOK, nil
+}
+
+// scanSpreadsheet exports the findings of the scan from the id parameter as CSV or XLSX.
+// Returns the HTTP status code to use together with the error if it fails
+func scanSpreadsheet(c *gin.Context, format string) (scan *semgrep.Scan, b []byte, status int, err error) {
+	scan, status, err = exportScan(c)
+	if err != nil {
+		return nil, nil, status, err
+	}
+
+	var buf bytes.Buffer
+	if format == "xlsx" {
+		err = scan.WriteXLSX(&buf)
+	} else {
+		err = scan.WriteCSV(&buf)
+	}
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	return scan, buf.Bytes(), http.StatusOK, nil
+}
+
+// sendSpreadsheet sends an export as a download named after the scan
+func sendSpreadsheet(c *gin.Context, scan *semgrep.Scan, format string, b []byte) {
+	contentType := csvContentType
+	if format == "xlsx" {
+		contentType = xlsxContentType
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "bagel-"+scan.ID.String()+"."+format))
+	c.Data(http.StatusOK, contentType, b)
+}
+
+// getScanCSV downloads the findings of a scan as a CSV file
+func getScanCSV(c *gin.Context) {
+	getScanSpreadsheet(c, "csv")
+}
+
+// getScanXLSX downloads the findings of a scan as an Excel workbook
+func getScanXLSX(c *gin.Context) {
+	getScanSpreadsheet(c, "xlsx")
+}
+
+// getScanSpreadsheet downloads the findings of a scan in the given format
+func getScanSpreadsheet(c *gin.Context, format string) {
+	scan, b, status, err := scanSpreadsheet(c, format)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	sendSpreadsheet(c, scan, format, b)
+}
+
+// apiGetScanCSV returns the findings of a scan as CSV
+func apiGetScanCSV(c *gin.Context) {
+	apiGetScanSpreadsheet(c, "csv")
+}
+
+// apiGetScanXLSX returns the findings of a scan as an Excel workbook
+func apiGetScanXLSX(c *gin.Context) {
+	apiGetScanSpreadsheet(c, "xlsx")
+}
+
+// apiGetScanSpreadsheet returns the findings of a scan in the given format
+func apiGetScanSpreadsheet(c *gin.Context, format string) {
+	scan, b, status, err := scanSpreadsheet(c, format)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	sendSpreadsheet(c, scan, format, b)
+}
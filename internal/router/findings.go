@@ -0,0 +1,65 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// triageFinding triages a finding with the fields status and comment, recording the logged in user.
+// Returns the HTTP status code to use together with the error if it fails
+func triageFinding(c *gin.Context) (finding *semgrep.Finding, status int, err error) {
+	scanID, findingID := c.Param("id"), c.Param("finding")
+	if err := validateID(scanID); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if err := validateID(findingID); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	user := currentUser(c)
+	if user == nil {
+		return nil, http.StatusUnauthorized, errors.New("not logged in")
+	}
+
+	comment := strings.TrimSpace(c.PostForm("comment"))
+	finding, err = semgrep.Triage(db, uuid.MustParse(scanID), uuid.MustParse(findingID), c.PostForm("status"), comment, user.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, semgrep.ErrFindingNotFound):
+			return nil, http.StatusNotFound, err
+		case errors.Is(err, semgrep.ErrInvalidTriageStatus), errors.Is(err, semgrep.ErrTriageCommentLength):
+			return nil, http.StatusBadRequest, err
+		default:
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	return finding, http.StatusOK, nil
+}
+
+// triageScanFinding triages a finding from the scan page and jumps back to it
+func triageScanFinding(c *gin.Context) {
+	finding, status, err := triageFinding(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/scan/"+finding.ScanID.String()+"#finding-"+finding.ID.String())
+}
+
+// apiTriageFinding triages a finding, see triageFinding for the fields
+func apiTriageFinding(c *gin.Context) {
+	finding, status, err := triageFinding(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newAPIFinding(finding))
+}
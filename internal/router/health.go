@@ -0,0 +1,152 @@
+package router
+
+import (
+	"bagel/internal/config"
+	"bagel/internal/database"
+	"bagel/internal/semgrep"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The status of a readiness check
+const (
+	checkOK      = "ok"
+	checkFailed  = "failed"
+	checkUnknown = "unknown" // The check is not supported on this platform, it does not fail the readiness
+)
+
+const (
+	// Time limit for all readiness checks together
+	readyTimeout = 10 * time.Second
+
+	// How long a successful check of Semgrep is reused, as starting Semgrep takes a while
+	semgrepCheckInterval = time.Minute
+)
+
+var (
+	// Minimum free space in the temp directory, where uploads are stored and unpacked
+	minFreeBytes = uint64(max(config.Int64("BAGEL_READY_MIN_FREE_BYTES", 100<<20), 0))
+
+	// The last successful check of Semgrep
+	semgrepCheckedAt time.Time
+	semgrepCheckMu   sync.Mutex
+)
+
+// healthCheck is the result of a single readiness check
+type healthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readiness is the result of all readiness checks, ok only if no check failed
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// getHealth reports that the process is alive
+func getHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": checkOK})
+}
+
+// getReady checks if scans can be accepted and run, with the result of every check
+func getReady(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+
+	r := readiness{
+		Status: checkOK,
+		Checks: map[string]healthCheck{
+			"database": checkDatabase(ctx),
+			"semgrep":  checkSemgrep(ctx),
+			"temp_dir": checkTempDir(),
+			"workers":  checkWorkers(),
+		},
+	}
+
+	status := http.StatusOK
+	for _, check := range r.Checks {
+		if check.Status == checkFailed {
+			r.Status = checkFailed
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(status, r)
+}
+
+// checkDatabase checks if the database can be queried
+func checkDatabase(ctx context.Context) healthCheck {
+	if err := database.Ping(ctx, db); err != nil {
+		return healthCheck{Status: checkFailed, Error: err.Error()}
+	}
+
+	return healthCheck{Status: checkOK}
+}
+
+// checkSemgrep checks if Semgrep can still be run, a successful check is reused for a while
+func checkSemgrep(ctx context.Context) healthCheck {
+	semgrepCheckMu.Lock()
+	defer semgrepCheckMu.Unlock()
+
+	if time.Since(semgrepCheckedAt) < semgrepCheckInterval {
+		return healthCheck{Status: checkOK, Detail: "checked at " + semgrepCheckedAt.Format(time.RFC3339)}
+	}
+
+	if err := semgrep.CheckRunnable(ctx); err != nil {
+		return healthCheck{Status: checkFailed, Error: err.Error()}
+	}
+
+	semgrepCheckedAt = time.Now()
+	return healthCheck{Status: checkOK, Detail: "checked at " + semgrepCheckedAt.Format(time.RFC3339)}
+}
+
+// checkTempDir checks if files can be written to the temp directory and if it has enough free space
+func checkTempDir() healthCheck {
+	dir := os.TempDir()
+
+	f, err := os.CreateTemp(dir, "bagel-ready-*")
+	if err != nil {
+		return healthCheck{Status: checkFailed, Error: err.Error()}
+	}
+	_, errWrite := f.WriteString("ok")
+	errClose := f.Close()
+	errRemove := os.Remove(f.Name())
+	if err := errors.Join(errWrite, errClose, errRemove); err != nil {
+		return healthCheck{Status: checkFailed, Error: err.Error()}
+	}
+
+	free, err := freeBytes(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return healthCheck{Status: checkUnknown, Detail: dir + " is writable, the free space is unknown on this platform"}
+	}
+	if err != nil {
+		return healthCheck{Status: checkFailed, Error: err.Error()}
+	}
+
+	detail := fmt.Sprintf("%d bytes free in %s", free, dir)
+	if free < minFreeBytes {
+		return healthCheck{Status: checkFailed, Detail: detail, Error: fmt.Sprintf("at least %d bytes have to be free", minFreeBytes)}
+	}
+
+	return healthCheck{Status: checkOK, Detail: detail}
+}
+
+// checkWorkers checks if all workers are started
+func checkWorkers() healthCheck {
+	running, configured := semgrep.Workers()
+	detail := fmt.Sprintf("%d of %d workers running", running, configured)
+	if running < configured {
+		return healthCheck{Status: checkFailed, Detail: detail, Error: "not all workers are started"}
+	}
+
+	return healthCheck{Status: checkOK, Detail: detail}
+}
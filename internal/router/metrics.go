@@ -0,0 +1,26 @@
+package router
+
+import (
+	"bagel/internal/config"
+	"bagel/internal/metrics"
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// Serve the metrics without a login, for scrapers that cannot send an API token
+	metricsPublic = config.Bool("BAGEL_METRICS_PUBLIC", false)
+)
+
+// getMetrics returns the metrics in the Prometheus text exposition format
+func getMetrics(c *gin.Context) {
+	var b bytes.Buffer
+	if err := metrics.Write(&b); err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	c.Data(http.StatusOK, metrics.ContentType, b.Bytes())
+}
@@ -1,34 +1,60 @@
 package router
 
 import (
+	"bagel/internal/auth"
 	"bagel/internal/logger"
-	"bytes"
-	"fmt"
+	"bagel/internal/metrics"
+	"bagel/internal/random"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// from https://github.com/gin-gonic/gin/issues/1363#issuecomment-577722498
-type responseBodyWriter struct {
-	gin.ResponseWriter
-	body *bytes.Buffer
+const (
+	// The header carrying the ID of a request, in both the request and the response
+	requestIDHeader = "X-Request-ID"
+
+	// Key of the request ID in the Gin context
+	contextRequestID = "request_id"
+)
+
+var (
+	// Request IDs accepted from clients, others are replaced so they cannot inject anything into the logs
+	regexRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+	metricRequestDuration = metrics.NewHistogram("bagel_http_request_duration_seconds", "Latency of HTTP requests by method, route and status code",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "method", "route", "status")
+)
+
+// RequestID is a middleware assigning an ID to every request, sent back in the X-Request-ID header.
+// A valid ID sent by a proxy in front of Bagel is kept, so requests can be followed across both logs
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !regexRequestID.MatchString(id) {
+			id = random.UUID().String()
+		}
+
+		c.Set(contextRequestID, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
 }
 
-// from https://github.com/gin-gonic/gin/issues/1363#issuecomment-577722498
-func (r responseBodyWriter) Write(b []byte) (int, error) {
-	r.body.Write(b)
-	return r.ResponseWriter.Write(b)
+// requestLog returns a logger adding the ID of the request to every log line
+func requestLog(c *gin.Context) *logger.Logger {
+	return logger.With("request_id", c.GetString(contextRequestID))
 }
 
 // Logger is a simple logger middleware to route Gin logs to the custom logger
 func Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// To capture the response body
-		// from https://github.com/gin-gonic/gin/issues/1363#issuecomment-577722498
-		w := &responseBodyWriter{body: &bytes.Buffer{}, ResponseWriter: c.Writer}
-		c.Writer = w
-
 		// Start timer to measure response time
 		start := time.Now()
 
@@ -37,6 +63,7 @@ func Logger() gin.HandlerFunc {
 
 		// Calculate the latency
 		latency := time.Since(start)
+		observeRequest(c, latency)
 
 		// Get the path and query string
 		path := c.Request.URL.Path
@@ -45,38 +72,93 @@ func Logger() gin.HandlerFunc {
 			path = path + "?" + rawQuery
 		}
 
-		// Assemble the log message
+		// Assemble the log message, the client is added as fields
 		statusCode := c.Writer.Status()
-		msg := fmt.Sprintf(
-			"%s %s %d %s %s %s",
-			c.Request.Method,
-			path,
-			statusCode,
-			latency,
-			c.ClientIP(),
-			c.Request.UserAgent(),
-		)
-
-		// Log the request based on the status code
+		log := requestLog(c).With("client_ip", c.ClientIP(), "user_agent", c.Request.UserAgent())
+
+		// Log the request based on the status code, errors of handlers are logged by ErrorHandler
 		if statusCode >= 500 {
-			logger.ErrorF("%s: %s", msg, w.body.String()) // include the response body
+			log.ErrorF("%s %s %d %s", c.Request.Method, path, statusCode, latency)
 		} else if statusCode >= 400 {
-			logger.Warning("%s: %s", msg, w.body.String()) // include the response body
+			log.Warning("%s %s %d %s", c.Request.Method, path, statusCode, latency)
 		} else {
-			logger.Info(msg)
+			log.Info("%s %s %d %s", c.Request.Method, path, statusCode, latency)
 		}
 	}
 }
 
+// observeRequest records the latency of a request by its route, so paths with IDs do not create a series each.
+// Event streams are left out, as they stay open as long as the client is connected
+func observeRequest(c *gin.Context, latency time.Duration) {
+	if c.Writer.Header().Get("Content-Type") == "text/event-stream" {
+		return
+	}
+
+	// Static files are served by NoRoute which has no full path
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+		if strings.HasPrefix(c.Request.URL.Path, "/static/") {
+			route = "/static"
+		}
+	}
+
+	metricRequestDuration.Observe(latency.Seconds(), c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+}
+
 // ErrorHandler is a handlerFunc to route Gin errors to the custom logger
 func ErrorHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
 
 		if len(c.Errors) > 0 {
+			log := requestLog(c)
 			for _, e := range c.Errors {
-				logger.Error(e.Err)
+				log.Error(e.Err)
 			}
 		}
 	}
 }
+
+// Auth is a middleware requiring a session cookie or an API token for all routes except the public ones.
+// API tokens are passed in the header "Authorization: Bearer <token>"
+func Auth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Static files are served by NoRoute which has no full path
+		if publicRoutes[c.Request.Method+" "+c.FullPath()] || strings.HasPrefix(c.Request.URL.Path, "/static/") {
+			c.Next()
+			return
+		}
+
+		var user *auth.User
+		var err error
+		if header := c.GetHeader("Authorization"); header != "" {
+			token, _ := strings.CutPrefix(header, "Bearer ")
+			user, err = auth.UserFromToken(db, token)
+		} else {
+			token, _ := c.Cookie(sessionCookie)
+			user, err = auth.UserFromSession(db, token)
+		}
+
+		if err != nil {
+			if !errors.Is(err, auth.ErrUnauthenticated) {
+				_ = c.Error(err)
+			}
+
+			switch {
+			case isAPI(c):
+				c.Header("WWW-Authenticate", "Bearer")
+				apiError(c, http.StatusUnauthorized, auth.ErrUnauthenticated)
+			case c.Request.Method == http.MethodGet:
+				c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+				c.Abort()
+			default:
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+			return
+		}
+
+		c.Set(contextUser, user)
+		c.Next()
+	}
+}
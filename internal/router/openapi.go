@@ -0,0 +1,470 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"bagel/internal/webhook"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// The OpenAPI specification generated from the routes when the router starts
+	spec openAPI
+)
+
+// The OpenAPI 3 specification, only the parts used by Bagel
+type openAPI struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       openAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components openAPIComponents               `json:"components"`
+	Security   []map[string][]string           `json:"security"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]schema         `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// operation documents a single route
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+
+	// Overrides the global security requirement, an empty list for public routes
+	Security *[]map[string][]string `json:"security,omitempty"`
+
+	// Public routes can be accessed without logging in
+	Public bool `json:"-"`
+}
+
+type parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Schema      schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema schema `json:"schema"`
+}
+
+type schema struct {
+	Ref         string            `json:"$ref,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Format      string            `json:"format,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Enum        []string          `json:"enum,omitempty"`
+	Items       *schema           `json:"items,omitempty"`
+	Properties  map[string]schema `json:"properties,omitempty"`
+	Required    []string          `json:"required,omitempty"`
+}
+
+// route is a route of the router together with its documentation
+type route struct {
+	Method    string
+	Path      string
+	Handler   gin.HandlerFunc
+	Operation operation
+}
+
+// Shorthands for the schemas and responses used by the routes
+var (
+	schemaString = schema{Type: "string"}
+	schemaBinary = schema{Type: "string", Format: "binary"}
+)
+
+// ref returns a schema referencing a component schema
+func ref(name string) schema {
+	return schema{Ref: "#/components/schemas/" + name}
+}
+
+// jsonResponse returns a response with a JSON body of the given schema
+func jsonResponse(description string, s schema) response {
+	return response{Description: description, Content: map[string]mediaType{"application/json": {Schema: s}}}
+}
+
+// sarifResponse returns a response with a SARIF log
+func sarifResponse(description string) response {
+	return response{Description: description, Content: map[string]mediaType{sarifContentType: {Schema: schema{Type: "object", Description: "A SARIF 2.1.0 log"}}}}
+}
+
+// fileResponse returns a response with a file of the given content type
+func fileResponse(description string, contentType string) response {
+	return response{Description: description, Content: map[string]mediaType{contentType: {Schema: schema{Type: "string", Format: "binary"}}}}
+}
+
+// eventStreamResponse returns a response with a stream of server-sent events
+func eventStreamResponse(description string) response {
+	return response{Description: description, Content: map[string]mediaType{"text/event-stream": {Schema: ref("ScanEvent")}}}
+}
+
+// textResponse returns a response with a plain text body
+func textResponse(description string) response {
+	return response{Description: description, Content: map[string]mediaType{"text/plain": {Schema: schemaString}}}
+}
+
+// htmlResponse returns a response with an HTML page
+func htmlResponse(description string) response {
+	return response{Description: description, Content: map[string]mediaType{"text/html": {Schema: schemaString}}}
+}
+
+// apiErrorResponse returns a response with a JSON error body
+func apiErrorResponse(description string) response {
+	return jsonResponse(description, ref("Error"))
+}
+
+// formBody returns a required request body with the given form fields
+func formBody(contentType string, properties map[string]schema, required ...string) *requestBody {
+	return &requestBody{
+		Required: true,
+		Content:  map[string]mediaType{contentType: {Schema: schema{Type: "object", Properties: properties, Required: required}}},
+	}
+}
+
+// queryParam returns an optional query parameter
+func queryParam(name string, description string, s schema) parameter {
+	return parameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+// specSchemas are the component schemas referenced by the routes
+func specSchemas() map[string]schema {
+	return map[string]schema{
+		"Error": {
+			Type:       "object",
+			Properties: map[string]schema{"error": schemaString},
+			Required:   []string{"error"},
+		},
+		"Scan": {
+			Type: "object",
+			Properties: map[string]schema{
+				"id":           {Type: "string", Format: "uuid"},
+				"name":         schemaString,
+				"project_id":   {Type: "string", Format: "uuid"},
+				"rulesets":     {Type: "array", Items: &schemaString},
+				"status":       {Type: "string", Enum: semgrep.Statuses},
+				"stage":        {Type: "string", Enum: semgrep.Stages, Description: "The stage of a running scan"},
+				"error":        schemaString,
+				"upload_name":  schemaString,
+				"git_url":      schemaString,
+				"git_ref":      schemaString,
+				"git_commit":   schemaString,
+				"upload_date":  {Type: "string", Format: "date-time"},
+				"started_at":   {Type: "string", Format: "date-time"},
+				"finished_at":  {Type: "string", Format: "date-time"},
+				"request_id":   {Type: "string", Description: "The ID of the request that created the scan, as in the X-Request-ID header and the logs"},
+				"status_url":   schemaString,
+				"findings_url": schemaString,
+			},
+			Required: []string{"id", "name", "rulesets", "status", "upload_name", "upload_date", "status_url", "findings_url"},
+		},
+		"ScanEvent": {
+			Type:        "object",
+			Description: "A status transition of a scan, sent as the data of a server-sent event",
+			Properties: map[string]schema{
+				"scan_id": {Type: "string", Format: "uuid"},
+				"state":   {Type: "string", Enum: append(slices.Clone(semgrep.Statuses), semgrep.Stages...), Description: "The stage of a running scan, otherwise the status"},
+				"status":  {Type: "string", Enum: semgrep.Statuses},
+				"error":   schemaString,
+				"time":    {Type: "string", Format: "date-time"},
+			},
+			Required: []string{"scan_id", "state", "status", "time"},
+		},
+		"ScanList": {
+			Type: "object",
+			Properties: map[string]schema{
+				"scans":    {Type: "array", Items: &schema{Ref: "#/components/schemas/Scan"}},
+				"page":     {Type: "integer"},
+				"per_page": {Type: "integer"},
+				"total":    {Type: "integer"},
+			},
+			Required: []string{"scans", "page", "per_page", "total"},
+		},
+		"Finding": {
+			Type: "object",
+			Properties: map[string]schema{
+				"id":          {Type: "string", Format: "uuid"},
+				"rule_id":     schemaString,
+				"path":        schemaString,
+				"start_line":  {Type: "integer"},
+				"start_col":   {Type: "integer"},
+				"end_line":    {Type: "integer"},
+				"end_col":     {Type: "integer"},
+				"severity":    schemaString,
+				"message":     schemaString,
+				"lines":       schemaString,
+				"metadata":    {Type: "object", Description: "The metadata of the Semgrep rule"},
+				"fingerprint": {Type: "string", Description: "Identifies the same finding across scans"},
+				"ignored":     {Type: "boolean", Description: "If the finding was ignored with a nosemgrep comment"},
+				"rulesets":    {Type: "array", Items: &schemaString},
+				"triage": {
+					Type: "object",
+					Properties: map[string]schema{
+						"status":     {Type: "string", Enum: semgrep.TriageStatuses},
+						"comment":    schemaString,
+						"triaged_by": schemaString,
+						"triaged_at": {Type: "string", Format: "date-time"},
+						"scan_id":    {Type: "string", Format: "uuid", Description: "The scan the decision was made in, differs from the scan of the finding if it was carried forward"},
+					},
+					Required: []string{"status", "comment"},
+				},
+			},
+			Required: []string{"id", "rule_id", "path", "start_line", "start_col", "end_line", "end_col", "severity", "message", "lines", "metadata", "fingerprint", "ignored", "rulesets", "triage"},
+		},
+		"Findings": {
+			Type: "object",
+			Properties: map[string]schema{
+				"scan_id":  {Type: "string", Format: "uuid"},
+				"findings": {Type: "array", Items: &schema{Ref: "#/components/schemas/Finding"}},
+				"page":     {Type: "integer"},
+				"per_page": {Type: "integer"},
+				"total":    {Type: "integer"},
+			},
+			Required: []string{"scan_id", "findings", "page", "per_page", "total"},
+		},
+		"ScanDiff": {
+			Type: "object",
+			Properties: map[string]schema{
+				"base_scan_id": {Type: "string", Format: "uuid", Description: "The earlier scan"},
+				"head_scan_id": {Type: "string", Format: "uuid", Description: "The later scan"},
+				"new":          {Type: "array", Items: &schema{Ref: "#/components/schemas/Finding"}, Description: "Findings of the later scan that are not in the earlier scan"},
+				"resolved":     {Type: "array", Items: &schema{Ref: "#/components/schemas/Finding"}, Description: "Findings of the earlier scan that are not in the later scan"},
+				"persisting":   {Type: "array", Items: &schema{Ref: "#/components/schemas/Finding"}, Description: "Findings of the later scan that were already in the earlier scan"},
+			},
+			Required: []string{"base_scan_id", "head_scan_id", "new", "resolved", "persisting"},
+		},
+		"Project": {
+			Type: "object",
+			Properties: map[string]schema{
+				"id":             {Type: "string", Format: "uuid"},
+				"name":           schemaString,
+				"description":    schemaString,
+				"rulesets":       {Type: "array", Items: &schemaString, Description: "The rulesets used for new scans of the project if none are selected"},
+				"created_at":     {Type: "string", Format: "date-time"},
+				"scan_count":     {Type: "integer"},
+				"latest_scan_id": {Type: "string", Format: "uuid", Description: "The latest scan that is done"},
+				"findings": {
+					Type:        "object",
+					Description: "The findings of the latest scan that is done",
+					Properties: map[string]schema{
+						"total":       {Type: "integer"},
+						"open":        {Type: "integer", Description: "Findings not triaged as false positive, won't fix or fixed"},
+						"by_severity": {Type: "object"},
+						"by_triage":   {Type: "object"},
+					},
+					Required: []string{"total", "open", "by_severity", "by_triage"},
+				},
+			},
+			Required: []string{"id", "name", "description", "rulesets", "created_at", "scan_count"},
+		},
+		"Ruleset": {
+			Type: "object",
+			Properties: map[string]schema{
+				"name":       schemaString,
+				"custom":     {Type: "boolean", Description: "If the ruleset was uploaded instead of coming from the registry"},
+				"local":      {Type: "boolean", Description: "If the ruleset is a file in the rules directory"},
+				"id":         {Type: "string", Format: "uuid", Description: "Only set for custom rulesets"},
+				"filename":   schemaString,
+				"rule_count": {Type: "integer", Description: "Not set for registry rulesets"},
+				"updated_at": {Type: "string", Format: "date-time", Description: "Not set for registry rulesets"},
+			},
+			Required: []string{"name", "custom", "local"},
+		},
+		"APIToken": {
+			Type: "object",
+			Properties: map[string]schema{
+				"id":           {Type: "string", Format: "uuid"},
+				"name":         schemaString,
+				"hint":         {Type: "string", Description: "The last characters of the token"},
+				"active":       {Type: "boolean"},
+				"created_at":   {Type: "string", Format: "date-time"},
+				"expires_at":   {Type: "string", Format: "date-time"},
+				"last_used_at": {Type: "string", Format: "date-time"},
+				"revoked_at":   {Type: "string", Format: "date-time"},
+				"token":        {Type: "string", Description: "The token itself, only returned once when it is created"},
+			},
+			Required: []string{"id", "name", "hint", "active", "created_at"},
+		},
+		"Health": {
+			Type:       "object",
+			Properties: map[string]schema{"status": {Type: "string", Enum: []string{checkOK}}},
+			Required:   []string{"status"},
+		},
+		"HealthCheck": {
+			Type:        "object",
+			Description: "The result of a single readiness check, unknown if it is not supported on the platform",
+			Properties: map[string]schema{
+				"status": {Type: "string", Enum: []string{checkOK, checkFailed, checkUnknown}},
+				"detail": schemaString,
+				"error":  schemaString,
+			},
+			Required: []string{"status"},
+		},
+		"Readiness": {
+			Type:        "object",
+			Description: "The results of all readiness checks, ok only if no check failed",
+			Properties: map[string]schema{
+				"status": {Type: "string", Enum: []string{checkOK, checkFailed}},
+				"checks": {
+					Type: "object",
+					Properties: map[string]schema{
+						"database": {Ref: "#/components/schemas/HealthCheck", Description: "The database can be queried"},
+						"semgrep":  {Ref: "#/components/schemas/HealthCheck", Description: "Semgrep can be run, a successful check is reused for a minute"},
+						"temp_dir": {Ref: "#/components/schemas/HealthCheck", Description: "The temp directory is writable and has BAGEL_READY_MIN_FREE_BYTES free"},
+						"workers":  {Ref: "#/components/schemas/HealthCheck", Description: "All workers are started"},
+					},
+					Required: []string{"database", "semgrep", "temp_dir", "workers"},
+				},
+			},
+			Required: []string{"status", "checks"},
+		},
+		"Webhook": {
+			Type:        "object",
+			Description: "An endpoint notified when scans finish",
+			Properties: map[string]schema{
+				"id":         {Type: "string", Format: "uuid"},
+				"name":       schemaString,
+				"url":        {Type: "string", Format: "uri"},
+				"secret":     {Type: "string", Description: "The key of the HMAC-SHA256 signature sent in the X-Bagel-Signature-256 header"},
+				"created_at": {Type: "string", Format: "date-time"},
+			},
+			Required: []string{"id", "name", "url", "secret", "created_at"},
+		},
+		"WebhookDelivery": {
+			Type:        "object",
+			Description: "A payload sent or to be sent to a webhook, with the result of the last attempt",
+			Properties: map[string]schema{
+				"id":              {Type: "string", Format: "uuid", Description: "Sent in the X-Bagel-Delivery header"},
+				"scan_id":         {Type: "string", Format: "uuid", Description: "Not set for test events"},
+				"event":           {Type: "string", Enum: []string{webhook.EventScanFinished, webhook.EventTest}},
+				"status":          {Type: "string", Enum: []string{webhook.DeliveryPending, webhook.DeliverySucceeded, webhook.DeliveryFailed}},
+				"attempts":        {Type: "integer"},
+				"response_status": {Type: "integer", Description: "The HTTP status code of the last attempt"},
+				"response_body":   {Type: "string", Description: "The start of the response body of the last attempt"},
+				"error":           {Type: "string", Description: "The error of the last attempt"},
+				"created_at":      {Type: "string", Format: "date-time"},
+				"delivered_at":    {Type: "string", Format: "date-time", Description: "The timestamp of the last attempt"},
+				"next_attempt_at": {Type: "string", Format: "date-time", Description: "The timestamp of the next attempt of pending deliveries"},
+			},
+			Required: []string{"id", "event", "status", "attempts", "created_at"},
+		},
+	}
+}
+
+// buildSpec generates the OpenAPI specification from the routes
+func buildSpec(routes []route) (doc openAPI) {
+	doc = openAPI{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "Bagel",
+			Description: "A simple web UI for Semgrep",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]map[string]operation{},
+		Components: openAPIComponents{
+			Schemas: specSchemas(),
+			SecuritySchemes: map[string]securityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer"},
+				"cookieAuth": {Type: "apiKey", In: "cookie", Name: sessionCookie},
+			},
+		},
+		Security: []map[string][]string{{"bearerAuth": {}}, {"cookieAuth": {}}},
+	}
+
+	for _, rt := range routes {
+		path, pathParams := specPath(rt.Path)
+
+		op := rt.Operation
+		op.OperationID = handlerName(rt.Handler)
+		op.Parameters = append(pathParams, op.Parameters...)
+		if op.Public {
+			op.Security = &[]map[string][]string{}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]operation{}
+		}
+		doc.Paths[path][strings.ToLower(rt.Method)] = op
+	}
+
+	return doc
+}
+
+// specPath converts a Gin path like /scan/:id into /scan/{id} and returns the path parameters
+func specPath(ginPath string) (path string, params []parameter) {
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+
+		name := strings.TrimPrefix(segment, ":")
+		segments[i] = "{" + name + "}"
+
+		s := schemaString
+		if name == "id" {
+			s = schema{Type: "string", Format: "uuid"}
+		}
+		params = append(params, parameter{Name: name, In: "path", Required: true, Schema: s})
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+// handlerName returns the function name of a handler, used as the operation ID
+func handlerName(handler gin.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// verifySpec checks that every route registered in Gin is part of the specification
+func verifySpec(doc openAPI, registered gin.RoutesInfo) (err error) {
+	for _, ri := range registered {
+		path, _ := specPath(ri.Path)
+		if _, ok := doc.Paths[path][strings.ToLower(ri.Method)]; !ok {
+			return fmt.Errorf("route %s %s is missing from the OpenAPI specification", ri.Method, ri.Path)
+		}
+	}
+
+	return nil
+}
+
+// getOpenAPI returns the OpenAPI specification
+func getOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, spec)
+}
@@ -0,0 +1,36 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestSpecCoversRoutes checks that every route registered with Gin is documented in the OpenAPI specification
+func TestSpecCoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	allRoutes := routes()
+	for _, rt := range allRoutes {
+		r.Handle(rt.Method, rt.Path, rt.Handler)
+	}
+
+	doc := buildSpec(allRoutes)
+	for _, ri := range r.Routes() {
+		path, _ := specPath(ri.Path)
+		op, ok := doc.Paths[path][strings.ToLower(ri.Method)]
+		if !ok {
+			t.Errorf("route %s %s is missing from the OpenAPI specification", ri.Method, ri.Path)
+			continue
+		}
+		if op.Summary == "" || len(op.Responses) == 0 {
+			t.Errorf("route %s %s has no summary or responses", ri.Method, ri.Path)
+		}
+	}
+
+	if err := verifySpec(doc, r.Routes()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,354 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// apiProject is the JSON representation of a project returned by the API
+type apiProject struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	Rulesets     []string          `json:"rulesets"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ScanCount    int64             `json:"scan_count"`
+	LatestScanID string            `json:"latest_scan_id,omitempty"`
+	Findings     *apiFindingCounts `json:"findings,omitempty"`
+}
+
+// apiFindingCounts are the findings of the latest scan of a project
+type apiFindingCounts struct {
+	Total      int            `json:"total"`
+	Open       int            `json:"open"`
+	BySeverity map[string]int `json:"by_severity"`
+	ByTriage   map[string]int `json:"by_triage"`
+}
+
+// newAPIProject converts a project summary into its JSON representation
+func newAPIProject(summary *semgrep.ProjectSummary) apiProject {
+	rulesets := summary.Rulesets.Names()
+	if rulesets == nil {
+		rulesets = []string{}
+	}
+
+	a := apiProject{
+		ID:          summary.ID.String(),
+		Name:        summary.Name,
+		Description: summary.Description,
+		Rulesets:    rulesets,
+		CreatedAt:   summary.CreatedAt,
+		ScanCount:   summary.ScanCount,
+	}
+	if summary.LatestScan != nil {
+		a.LatestScanID = summary.LatestScan.ID.String()
+	}
+	if summary.Counts != nil {
+		a.Findings = &apiFindingCounts{
+			Total:      summary.Counts.Total,
+			Open:       summary.Counts.Open,
+			BySeverity: summary.Counts.BySeverity,
+			ByTriage:   summary.Counts.ByTriage,
+		}
+	}
+
+	return a
+}
+
+// listProjects displays all projects with the findings of their latest scan and the form to create a new one
+func listProjects(c *gin.Context) {
+	summaries, err := summarizeProjects()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	rulesets, err := semgrep.ListRulesets(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	render(c, http.StatusOK, "projects.tmpl", gin.H{"Title": "Projects", "Projects": summaries, "Rulesets": rulesets})
+}
+
+// newProject accepts a POST request with a form containing a name, a description and the default rulesets
+func newProject(c *gin.Context) {
+	project, status, err := createProject(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/projects/"+project.ID.String())
+}
+
+// getProject displays a project with its scan history and the form to change it
+func getProject(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	project, err := semgrep.FindProject(db, uuid.MustParse(id))
+	if err != nil {
+		if errors.Is(err, semgrep.ErrProjectNotFound) {
+			c.String(http.StatusNotFound, "Project not found")
+			return
+		}
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	summary, err := project.Summarize(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	scans, findings, err := project.Scans(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	rulesets, err := semgrep.ListRulesets(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	render(c, http.StatusOK, "project.tmpl", gin.H{
+		"Title":          project.Name,
+		"Project":        summary,
+		"Scans":          scans,
+		"Findings":       findings,
+		"Rulesets":       rulesets,
+		"Selected":       project.Rulesets.Names(),
+		"TriageStatuses": semgrep.TriageStatuses,
+	})
+}
+
+// editProject changes a project from the form on the project page
+func editProject(c *gin.Context) {
+	project, status, err := updateProject(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/projects/"+project.ID.String())
+}
+
+// deleteProject removes a project, its scans are kept
+func deleteProject(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if err := semgrep.DeleteProject(db, uuid.MustParse(id)); err != nil {
+		if errors.Is(err, semgrep.ErrProjectNotFound) {
+			c.String(http.StatusNotFound, "Project not found")
+			return
+		}
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/projects")
+}
+
+// summarizeProjects returns all projects together with the findings of their latest scan
+func summarizeProjects() (summaries []*semgrep.ProjectSummary, err error) {
+	projects, err := semgrep.ListProjects(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range projects {
+		summary, err := projects[i].Summarize(db)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+// projectForm reads the fields name, description and the repeatable ruleset of a project form.
+// Returns the HTTP status code to use together with the error if it fails
+func projectForm(c *gin.Context) (name string, description string, rulesets semgrep.RulesetList, status int, err error) {
+	name = SanitizeHTML(strings.TrimSpace(c.PostForm("name")))
+	description = SanitizeHTML(strings.TrimSpace(c.PostForm("description")))
+
+	// Default rulesets are optional
+	if names := c.PostFormArray("ruleset"); len(names) > 0 {
+		rulesets, err = semgrep.FindRulesets(db, names)
+		if err != nil {
+			if errors.Is(err, semgrep.ErrRulesetNotFound) {
+				return "", "", nil, http.StatusBadRequest, err
+			}
+			return "", "", nil, http.StatusInternalServerError, err
+		}
+	}
+
+	return name, description, rulesets, http.StatusOK, nil
+}
+
+// createProject creates a project from the form, see projectForm for the fields.
+// Returns the HTTP status code to use together with the error if it fails
+func createProject(c *gin.Context) (project *semgrep.Project, status int, err error) {
+	name, description, rulesets, status, err := projectForm(c)
+	if err != nil {
+		return nil, status, err
+	}
+
+	project, err = semgrep.CreateProject(db, name, description, rulesets)
+	if err != nil {
+		return nil, projectErrorStatus(err), err
+	}
+
+	return project, http.StatusCreated, nil
+}
+
+// updateProject replaces the name, description and default rulesets of a project with the form.
+// Returns the HTTP status code to use together with the error if it fails
+func updateProject(c *gin.Context) (project *semgrep.Project, status int, err error) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	project, err = semgrep.FindProject(db, uuid.MustParse(id))
+	if err != nil {
+		return nil, projectErrorStatus(err), err
+	}
+
+	project.Name, project.Description, project.Rulesets, status, err = projectForm(c)
+	if err != nil {
+		return nil, status, err
+	}
+
+	if err := semgrep.UpdateProject(db, project); err != nil {
+		return nil, projectErrorStatus(err), err
+	}
+
+	return project, http.StatusOK, nil
+}
+
+// projectErrorStatus returns the HTTP status code for an error of the project functions
+func projectErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, semgrep.ErrProjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, semgrep.ErrProjectExists):
+		return http.StatusConflict
+	case errors.Is(err, semgrep.ErrEmptyProjectName):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// findProject loads the project from the id parameter, the error is already sent if it fails
+func findProject(c *gin.Context) (project *semgrep.Project, ok bool) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	project, err := semgrep.FindProject(db, uuid.MustParse(id))
+	if err != nil {
+		apiError(c, projectErrorStatus(err), err)
+		return nil, false
+	}
+
+	return project, true
+}
+
+// apiListProjects lists all projects with the findings of their latest scan
+func apiListProjects(c *gin.Context) {
+	summaries, err := summarizeProjects()
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := []apiProject{}
+	for _, summary := range summaries {
+		list = append(list, newAPIProject(summary))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// apiNewProject creates a project, see projectForm for the fields
+func apiNewProject(c *gin.Context) {
+	project, status, err := createProject(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newAPIProject(&semgrep.ProjectSummary{Project: *project}))
+}
+
+// apiGetProject returns a project with the findings of its latest scan
+func apiGetProject(c *gin.Context) {
+	project, ok := findProject(c)
+	if !ok {
+		return
+	}
+
+	summary, err := project.Summarize(db)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newAPIProject(summary))
+}
+
+// apiUpdateProject replaces a project, see projectForm for the fields
+func apiUpdateProject(c *gin.Context) {
+	project, status, err := updateProject(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	summary, err := project.Summarize(db)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newAPIProject(summary))
+}
+
+// apiDeleteProject removes a project, its scans are kept
+func apiDeleteProject(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := semgrep.DeleteProject(db, uuid.MustParse(id)); err != nil {
+		apiError(c, projectErrorStatus(err), err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
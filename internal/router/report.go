@@ -0,0 +1,143 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	pdfContentType = "application/pdf"
+)
+
+var (
+	// The stylesheets of the static files a report inlines, in the order they are applied
+	reportStylesheets = []string{"static/main.css", "static/scan.css", "static/report.css"}
+
+	// The fonts referenced by the stylesheets, replaced by data URLs in reports
+	fontURL = regexp.MustCompile(`url\("/static/([A-Za-z0-9-]+\.woff2)"\)`)
+)
+
+// scanReport summarizes the findings of the scan from the id parameter for a report.
+// Returns the HTTP status code to use together with the error if it fails
+func scanReport(c *gin.Context) (report *semgrep.Report, status int, err error) {
+	scan, status, err := exportScan(c)
+	if err != nil {
+		return nil, status, err
+	}
+
+	report, err = scan.NewReport()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return report, http.StatusOK, nil
+}
+
+// reportStyles returns the stylesheets for a report with the fonts inlined, so the report needs no other files
+func reportStyles() (styles template.CSS, err error) {
+	var b bytes.Buffer
+	for _, name := range reportStylesheets {
+		css, err := EmbedFSStatic.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		b.Write(css)
+		b.WriteByte('\n')
+	}
+
+	css := fontURL.ReplaceAllStringFunc(b.String(), func(url string) string {
+		font, readErr := EmbedFSStatic.ReadFile(path.Join("static", fontURL.FindStringSubmatch(url)[1]))
+		if readErr != nil {
+			err = readErr
+			return url
+		}
+		return `url("data:font/woff2;base64,` + base64.StdEncoding.EncodeToString(font) + `")`
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// #nosec G203 - The stylesheets are the embedded static files
+	return template.CSS(css), nil
+}
+
+// sendReport renders the report as HTML or PDF, the file is named after the scan
+func sendReport(c *gin.Context, report *semgrep.Report, format string, sendError func(status int, err error)) {
+	filename := "bagel-report-" + report.Scan.ID.String() + "." + format
+
+	if format == "pdf" {
+		var b bytes.Buffer
+		if err := report.WritePDF(&b); err != nil {
+			sendError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Data(http.StatusOK, pdfContentType, b.Bytes())
+		return
+	}
+
+	styles, err := reportStyles()
+	if err != nil {
+		sendError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// Shown in the browser, saving it keeps the name
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	c.HTML(http.StatusOK, "report.tmpl", gin.H{"Title": "Semgrep report: " + report.Scan.ScanName, "Report": report, "Styles": styles})
+}
+
+// getScanReport shows a self-contained HTML report of a scan
+func getScanReport(c *gin.Context) {
+	getScanReportFormat(c, "html")
+}
+
+// getScanPDF downloads the report of a scan as a PDF file
+func getScanPDF(c *gin.Context) {
+	getScanReportFormat(c, "pdf")
+}
+
+// getScanReportFormat sends the report of a scan in the given format
+func getScanReportFormat(c *gin.Context, format string) {
+	sendError := func(status int, err error) { c.String(status, "%s", err) }
+
+	report, status, err := scanReport(c)
+	if err != nil {
+		sendError(status, err)
+		return
+	}
+
+	sendReport(c, report, format, sendError)
+}
+
+// apiGetScanReport returns a self-contained HTML report of a scan
+func apiGetScanReport(c *gin.Context) {
+	apiGetScanReportFormat(c, "html")
+}
+
+// apiGetScanPDF returns the report of a scan as a PDF file
+func apiGetScanPDF(c *gin.Context) {
+	apiGetScanReportFormat(c, "pdf")
+}
+
+// apiGetScanReportFormat returns the report of a scan in the given format
+func apiGetScanReportFormat(c *gin.Context, format string) {
+	sendError := func(status int, err error) { apiError(c, status, err) }
+
+	report, status, err := scanReport(c)
+	if err != nil {
+		sendError(status, err)
+		return
+	}
+
+	sendReport(c, report, format, sendError)
+}
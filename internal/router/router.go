@@ -2,12 +2,16 @@ package router
 
 import (
 	"bagel/internal/logger"
+	"bagel/internal/semgrep"
 	"context"
 	"embed"
+	"encoding/json"
 	"html/template"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -38,10 +42,18 @@ func Start(database *gorm.DB) {
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
-	r.Use(gin.Recovery(), Logger(), ErrorHandler())
+	r.Use(gin.Recovery(), RequestID(), Logger(), ErrorHandler(), Auth())
 
 	// Add custom functions to the template
-	funcMaps := template.FuncMap{}
+	funcMaps := template.FuncMap{
+		"triageLabel": semgrep.TriageLabel,
+		"contains":    slices.Contains[[]string, string],
+		"lower":       strings.ToLower,
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
 
 	// Load the templates from the embedded filesystem
 	templ := template.New("").Funcs(funcMaps)
@@ -51,14 +63,23 @@ func Start(database *gorm.DB) {
 	}
 	r.SetHTMLTemplate(templ)
 
-	// Register the routes
-	r.GET("/", listScans)
-	r.POST("/scan/new", newScan)
-	r.GET("/scan/:id", getScan)
-	r.GET("/scan/:id/json", getScanJSON)
-	r.DELETE("/scan/:id", deleteScan)
+	// Register the routes and generate the OpenAPI specification from them
+	allRoutes := routes()
+	for _, rt := range allRoutes {
+		r.Handle(rt.Method, rt.Path, rt.Handler)
+		if rt.Operation.Public {
+			publicRoutes[rt.Method+" "+rt.Path] = true
+		}
+	}
+
+	// Routes missing from the specification are caught by the tests, this only reports them
+	spec = buildSpec(allRoutes)
+	if err := verifySpec(spec, r.Routes()); err != nil {
+		logger.Warning("%s", err)
+	}
 
-	r.Use(static.ServeEmbed("", EmbedFSStatic))
+	// Everything else is either a static file or an unknown route
+	r.NoRoute(noRoute(static.ServeEmbed("", EmbedFSStatic)))
 
 	addr := defaultAddr
 	if os.Getenv("INSIDETHEMATRIX") != "true" {
@@ -74,6 +95,9 @@ func Start(database *gorm.DB) {
 		WriteTimeout:      10 * time.Second,
 	}
 
+	// Event streams only end on their own once their scan is finished
+	srv.RegisterOnShutdown(func() { close(chanStopStreams) })
+
 	go func() {
 		logger.Info("Starting server on %s", addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -0,0 +1,792 @@
+package router
+
+import (
+	"bagel/internal/metrics"
+	"bagel/internal/semgrep"
+	"net/http"
+)
+
+var (
+	tagsUI   = []string{"UI"}
+	tagsAPI  = []string{"API"}
+	tagsSpec = []string{"Specification"}
+	tagsAuth = []string{"Authentication"}
+	tagsMon  = []string{"Monitoring"}
+
+	// Form fields to create a scan, shared by the UI and the API
+	scanFormFields = map[string]schema{
+		"name":    {Type: "string", Description: "The name of the scan"},
+		"ruleset": {Type: "array", Items: &schemaString, Description: "The names of the registry or custom rulesets to use, repeat the field for multiple rulesets"},
+		"file":    {Type: "string", Format: "binary", Description: "The archive to scan, required if git_url is not set"},
+		"git_url": {Type: "string", Description: "The URL of a Git repository to clone instead of uploading a file"},
+		"git_ref": {Type: "string", Description: "The branch, tag or commit SHA to check out, defaults to the default branch"},
+		"project": {Type: "string", Format: "uuid", Description: "The ID of the project the scan belongs to, its default rulesets are used if no ruleset is set"},
+	}
+
+	// Form fields to create or change a project, shared by the UI and the API
+	projectFormFields = map[string]schema{
+		"name":        {Type: "string", Description: "The name of the project"},
+		"description": {Type: "string", Description: "A description of the codebase"},
+		"ruleset":     {Type: "array", Items: &schemaString, Description: "The default rulesets for new scans, repeat the field for multiple rulesets"},
+	}
+
+	// Form fields to upload a custom ruleset, shared by the UI and the API
+	rulesFormFields = map[string]schema{
+		"name": {Type: "string", Description: "The name to select the ruleset by"},
+		"file": {Type: "string", Format: "binary", Description: "The Semgrep rules YAML file"},
+	}
+
+	// Form fields to snapshot a registry ruleset, shared by the UI and the API
+	snapshotFormFields = map[string]schema{
+		"name": {Type: "string", Description: "The name of the registry ruleset, like python"},
+	}
+
+	// Form fields to create an API token, shared by the UI and the API
+	tokenFormFields = map[string]schema{
+		"name":            {Type: "string", Description: "A name to recognize the token"},
+		"expires_in_days": {Type: "integer", Description: "Let the token expire after this many days, never expires if not set"},
+	}
+
+	// Form fields to create a webhook, shared by the UI and the API
+	webhookFormFields = map[string]schema{
+		"name":   {Type: "string", Description: "A name to recognize the webhook"},
+		"url":    {Type: "string", Format: "uri", Description: "The http or https URL the payloads are posted to"},
+		"secret": {Type: "string", Description: "The key of the HMAC-SHA256 signature, a random one is generated if not set"},
+	}
+
+	// Form fields to triage a finding, shared by the UI and the API
+	triageFormFields = map[string]schema{
+		"status":  {Type: "string", Enum: semgrep.TriageStatuses, Description: "The decision for the finding"},
+		"comment": {Type: "string", Description: "Why the decision was made"},
+	}
+)
+
+// routes returns all routes of the router. The OpenAPI specification is generated from this list
+func routes() []route {
+	return []route{
+		// Web UI
+		{http.MethodGet, "/", listScans, operation{
+			Summary:   "Show the form for a new scan and the list of scans",
+			Tags:      tagsUI,
+			Responses: map[string]response{"200": htmlResponse("The list of scans")},
+		}},
+		{http.MethodPost, "/scan/new", newScan, operation{
+			Summary:     "Create a new scan",
+			Tags:        tagsUI,
+			RequestBody: formBody("multipart/form-data", scanFormFields, "name"),
+			Responses: map[string]response{
+				"302": {Description: "The scan was added to the queue, redirects to the list of scans"},
+				"400": textResponse("The form is invalid"),
+				"500": textResponse("The upload could not be saved"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id", getScan, operation{
+			Summary: "Show the results of a scan, or its progress while it is not finished",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": htmlResponse("The results or the progress of the scan"),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/json", getScanJSON, operation{
+			Summary: "Get the raw Semgrep output of a scan",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": jsonResponse("The Semgrep output", schema{Type: "object"}),
+				"400": textResponse("The ID is invalid"),
+				"403": textResponse("The scan is not finished"),
+				"404": textResponse("The scan does not exist"),
+				"500": textResponse("The scan had an error"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/sarif", getScanSARIF, operation{
+			Summary: "Download the findings of a scan as a SARIF file",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": sarifResponse("The SARIF file, ignored and dismissed findings are suppressed"),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+				"409": textResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/csv", getScanCSV, operation{
+			Summary: "Download the findings of a scan as a CSV file, one row per finding",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": fileResponse("The findings", csvContentType),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+				"409": textResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/xlsx", getScanXLSX, operation{
+			Summary: "Download the findings of a scan as an Excel workbook, one row per finding",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": fileResponse("The findings", xlsxContentType),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+				"409": textResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/report", getScanReport, operation{
+			Summary: "Show a self-contained HTML report of a scan with an executive summary and all findings",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": htmlResponse("The report, with the styles and fonts inlined"),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+				"409": textResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/pdf", getScanPDF, operation{
+			Summary: "Download the report of a scan as a PDF file",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": fileResponse("The report", pdfContentType),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+				"409": textResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/events", getScanEvents, operation{
+			Summary: "Stream the status transitions of a scan as server-sent events until it is finished",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": eventStreamResponse("The current state of the scan followed by its transitions"),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+			},
+		}},
+		{http.MethodGet, "/events", getEvents, operation{
+			Summary: "Stream the status transitions of all scans as server-sent events",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": eventStreamResponse("The current state of the unfinished scans followed by the transitions of all scans"),
+			},
+		}},
+		{http.MethodDelete, "/scan/:id", deleteScan, operation{
+			Summary: "Delete a scan, cancels it first if it is not finished",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"302": {Description: "The scan was deleted, redirects to the list of scans"},
+				"400": textResponse("The ID is invalid"),
+			},
+		}},
+		{http.MethodGet, "/scan/:id/diff", getScanDiff, operation{
+			Summary: "Compare the findings of a scan with an earlier scan",
+			Tags:    tagsUI,
+			Parameters: []parameter{
+				queryParam("base", "The ID of the scan to compare with, defaults to the previous scan of the same codebase", schema{Type: "string", Format: "uuid"}),
+			},
+			Responses: map[string]response{
+				"200": htmlResponse("The new, resolved and persisting findings"),
+				"400": textResponse("An ID is invalid"),
+				"404": textResponse("A scan does not exist or there is no earlier scan"),
+				"409": textResponse("A scan is not done"),
+			},
+		}},
+		{http.MethodPost, "/scan/:id/findings/:finding/triage", triageScanFinding, operation{
+			Summary:     "Triage a finding of a scan, records the logged in user",
+			Tags:        tagsUI,
+			RequestBody: formBody("application/x-www-form-urlencoded", triageFormFields, "status"),
+			Responses: map[string]response{
+				"302": {Description: "The finding was triaged, redirects to the finding on the scan page"},
+				"400": textResponse("The ID, the status or the comment is invalid"),
+				"404": textResponse("The finding does not exist in the scan"),
+			},
+		}},
+		{http.MethodPost, "/scan/:id/cancel", cancelScan, operation{
+			Summary: "Cancel a queued or running scan",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"302": {Description: "The scan was cancelled, redirects to the list of scans"},
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The scan does not exist"),
+				"409": textResponse("The scan is already finished"),
+			},
+		}},
+
+		{http.MethodGet, "/projects", listProjects, operation{
+			Summary:   "Show the projects and the form for a new project",
+			Tags:      tagsUI,
+			Responses: map[string]response{"200": htmlResponse("The list of projects")},
+		}},
+		{http.MethodPost, "/projects", newProject, operation{
+			Summary:     "Create a project",
+			Tags:        tagsUI,
+			RequestBody: formBody("application/x-www-form-urlencoded", projectFormFields, "name"),
+			Responses: map[string]response{
+				"302": {Description: "The project was created, redirects to the project"},
+				"400": textResponse("The form is invalid"),
+				"409": textResponse("A project with the name already exists"),
+			},
+		}},
+		{http.MethodGet, "/projects/:id", getProject, operation{
+			Summary: "Show a project with its scan history and the findings of the latest scan",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": htmlResponse("The project"),
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The project does not exist"),
+			},
+		}},
+		{http.MethodPost, "/projects/:id", editProject, operation{
+			Summary:     "Change the name, description and default rulesets of a project",
+			Tags:        tagsUI,
+			RequestBody: formBody("application/x-www-form-urlencoded", projectFormFields, "name"),
+			Responses: map[string]response{
+				"302": {Description: "The project was changed, redirects to the project"},
+				"400": textResponse("The form is invalid"),
+				"404": textResponse("The project does not exist"),
+				"409": textResponse("A project with the name already exists"),
+			},
+		}},
+		{http.MethodDelete, "/projects/:id", deleteProject, operation{
+			Summary: "Delete a project, its scans are kept",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"302": {Description: "The project was deleted, redirects to the list of projects"},
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The project does not exist"),
+			},
+		}},
+
+		{http.MethodGet, "/rules", listRules, operation{
+			Summary:   "Show the local and custom rulesets and the forms to upload new ones or snapshot the registry",
+			Tags:      tagsUI,
+			Responses: map[string]response{"200": htmlResponse("The list of custom rulesets")},
+		}},
+		{http.MethodPost, "/rules", newRules, operation{
+			Summary:     "Upload a custom ruleset",
+			Tags:        tagsUI,
+			RequestBody: formBody("multipart/form-data", rulesFormFields, "name", "file"),
+			Responses: map[string]response{
+				"302": {Description: "The ruleset was added, redirects to the list of rulesets"},
+				"400": textResponse("The form is invalid or Semgrep rejected the rules"),
+				"409": textResponse("A ruleset with this name already exists"),
+			},
+		}},
+		{http.MethodPost, "/rules/snapshot", snapshotRules, operation{
+			Summary:     "Download a registry ruleset into the rules directory for offline use, only for admins",
+			Tags:        tagsUI,
+			RequestBody: formBody("application/x-www-form-urlencoded", snapshotFormFields, "name"),
+			Responses: map[string]response{
+				"302": {Description: "The snapshot was saved, redirects to the list of rulesets"},
+				"400": textResponse("The name is not one of the registry rulesets"),
+				"403": textResponse("The logged in user is not an admin"),
+				"404": textResponse("The registry ruleset does not exist"),
+				"409": textResponse("A local ruleset with this name exists that is not a snapshot"),
+				"502": textResponse("The registry returned invalid rules"),
+			},
+		}},
+		{http.MethodDelete, "/rules/:id", deleteRules, operation{
+			Summary: "Delete a custom ruleset",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"302": {Description: "The ruleset was deleted, redirects to the list of rulesets"},
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The ruleset does not exist"),
+			},
+		}},
+
+		{http.MethodGet, "/webhooks", listWebhooks, operation{
+			Summary: "Show the webhooks and the form to create one, only for admins",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": htmlResponse("The list of webhooks"),
+				"403": textResponse("The logged in user is not an admin"),
+			},
+		}},
+		{http.MethodPost, "/webhooks", newWebhook, operation{
+			Summary:     "Create a webhook, only for admins",
+			Tags:        tagsUI,
+			RequestBody: formBody("application/x-www-form-urlencoded", webhookFormFields, "name", "url"),
+			Responses: map[string]response{
+				"302": {Description: "The webhook was created, redirects to the webhook"},
+				"400": textResponse("The form is invalid"),
+				"403": textResponse("The logged in user is not an admin"),
+			},
+		}},
+		{http.MethodGet, "/webhooks/:id", getWebhook, operation{
+			Summary: "Show a webhook with its secret and delivery log, only for admins",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"200": htmlResponse("The webhook"),
+				"400": textResponse("The ID is invalid"),
+				"403": textResponse("The logged in user is not an admin"),
+				"404": textResponse("The webhook does not exist"),
+			},
+		}},
+		{http.MethodPost, "/webhooks/:id/test", testWebhook, operation{
+			Summary: "Send a test event to a webhook, only for admins",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"302": {Description: "The test event was sent, redirects to the webhook with the result in its delivery log"},
+				"400": textResponse("The ID is invalid"),
+				"403": textResponse("The logged in user is not an admin"),
+				"404": textResponse("The webhook does not exist"),
+			},
+		}},
+		{http.MethodDelete, "/webhooks/:id", deleteWebhook, operation{
+			Summary: "Delete a webhook and its delivery log, only for admins",
+			Tags:    tagsUI,
+			Responses: map[string]response{
+				"302": {Description: "The webhook was deleted, redirects to the list of webhooks"},
+				"400": textResponse("The ID is invalid"),
+				"403": textResponse("The logged in user is not an admin"),
+				"404": textResponse("The webhook does not exist"),
+			},
+		}},
+
+		// Authentication
+		{http.MethodGet, "/login", showLogin, operation{
+			Summary:    "Show the login form",
+			Tags:       tagsAuth,
+			Public:     true,
+			Parameters: []parameter{queryParam("next", "The local path to redirect to after logging in", schemaString)},
+			Responses:  map[string]response{"200": htmlResponse("The login form")},
+		}},
+		{http.MethodPost, "/login", login, operation{
+			Summary: "Log in and set the session cookie",
+			Tags:    tagsAuth,
+			Public:  true,
+			RequestBody: formBody("application/x-www-form-urlencoded", map[string]schema{
+				"username": schemaString,
+				"password": {Type: "string", Format: "password"},
+				"next":     {Type: "string", Description: "The local path to redirect to after logging in"},
+			}, "username", "password"),
+			Responses: map[string]response{
+				"302": {Description: "Logged in, redirects to the next path"},
+				"401": htmlResponse("The credentials are invalid"),
+			},
+		}},
+		{http.MethodPost, "/logout", logout, operation{
+			Summary:   "Log out and remove the session cookie",
+			Tags:      tagsAuth,
+			Responses: map[string]response{"302": {Description: "Logged out, redirects to the login form"}},
+		}},
+		{http.MethodGet, "/account", showAccount, operation{
+			Summary:   "Show the account page with the API tokens and, for admins, the users",
+			Tags:      tagsAuth,
+			Responses: map[string]response{"200": htmlResponse("The account page")},
+		}},
+		{http.MethodPost, "/account/password", changePassword, operation{
+			Summary: "Change the password, ends all other sessions",
+			Tags:    tagsAuth,
+			RequestBody: formBody("application/x-www-form-urlencoded", map[string]schema{
+				"current":  {Type: "string", Format: "password"},
+				"password": {Type: "string", Format: "password", Description: "The new password"},
+			}, "current", "password"),
+			Responses: map[string]response{
+				"302": {Description: "The password was changed, redirects to the account page"},
+				"400": textResponse("The current password is wrong or the new one is invalid"),
+			},
+		}},
+		{http.MethodPost, "/account/tokens", createToken, operation{
+			Summary:     "Create an API token and show it once",
+			Tags:        tagsAuth,
+			RequestBody: formBody("application/x-www-form-urlencoded", tokenFormFields, "name"),
+			Responses: map[string]response{
+				"201": htmlResponse("The account page with the new token"),
+				"400": textResponse("The form is invalid"),
+			},
+		}},
+		{http.MethodDelete, "/account/tokens/:id", revokeToken, operation{
+			Summary: "Revoke an API token",
+			Tags:    tagsAuth,
+			Responses: map[string]response{
+				"302": {Description: "The token was revoked, redirects to the account page"},
+				"400": textResponse("The ID is invalid"),
+				"404": textResponse("The token does not exist"),
+			},
+		}},
+		{http.MethodPost, "/account/users", createUser, operation{
+			Summary: "Create a user, only for admins",
+			Tags:    tagsAuth,
+			RequestBody: formBody("application/x-www-form-urlencoded", map[string]schema{
+				"username": schemaString,
+				"password": {Type: "string", Format: "password"},
+				"admin":    {Type: "string", Description: "Set to on to create an admin"},
+			}, "username", "password"),
+			Responses: map[string]response{
+				"302": {Description: "The user was created, redirects to the account page"},
+				"400": textResponse("The form is invalid or the user exists"),
+				"403": textResponse("The logged in user is not an admin"),
+			},
+		}},
+		{http.MethodDelete, "/account/users/:id", deleteUser, operation{
+			Summary: "Delete a user, only for admins",
+			Tags:    tagsAuth,
+			Responses: map[string]response{
+				"302": {Description: "The user was deleted, redirects to the account page"},
+				"400": textResponse("The ID is invalid"),
+				"403": textResponse("The logged in user is not an admin"),
+				"404": textResponse("The user does not exist"),
+				"409": textResponse("The user is the last admin"),
+			},
+		}},
+
+		// JSON API
+		{http.MethodPost, "/api/v1/scans", apiNewScan, operation{
+			Summary:     "Create a new scan",
+			Tags:        tagsAPI,
+			RequestBody: formBody("multipart/form-data", scanFormFields, "name"),
+			Responses: map[string]response{
+				"202": jsonResponse("The scan was added to the queue", ref("Scan")),
+				"400": apiErrorResponse("The form is invalid"),
+				"500": apiErrorResponse("The upload could not be saved"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans", apiListScans, operation{
+			Summary: "List scans, newest first",
+			Tags:    tagsAPI,
+			Parameters: []parameter{
+				queryParam("page", "The page to return, starting at 1", schema{Type: "integer"}),
+				queryParam("per_page", "The number of scans per page, at most 100", schema{Type: "integer"}),
+				queryParam("status", "Only return scans with this status", schema{Type: "string", Enum: semgrep.Statuses}),
+				queryParam("ruleset", "Only return scans using this ruleset, among others", schemaString),
+				queryParam("project", "Only return scans of this project", schema{Type: "string", Format: "uuid"}),
+				queryParam("name", "Only return scans with a name containing this string", schemaString),
+			},
+			Responses: map[string]response{
+				"200": jsonResponse("A page of scans", ref("ScanList")),
+				"400": apiErrorResponse("The pagination is invalid"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id", apiGetScan, operation{
+			Summary: "Get the status of a scan",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": jsonResponse("The scan", ref("Scan")),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/findings", apiGetFindings, operation{
+			Summary: "Get the findings of a scan that is done, ordered by path and line",
+			Tags:    tagsAPI,
+			Parameters: []parameter{
+				queryParam("page", "The page to return, starting at 1", schema{Type: "integer"}),
+				queryParam("per_page", "The number of findings per page, at most 100", schema{Type: "integer"}),
+				queryParam("severity", "Only return findings with this severity, like ERROR or WARNING", schemaString),
+				queryParam("rule_id", "Only return findings of this rule", schemaString),
+				queryParam("ruleset", "Only return findings of rules from this ruleset", schemaString),
+				queryParam("path", "Only return findings in paths starting with this string", schemaString),
+				queryParam("triage", "Only return findings with this triage status", schema{Type: "string", Enum: semgrep.TriageStatuses}),
+			},
+			Responses: map[string]response{
+				"200": jsonResponse("A page of findings", ref("Findings")),
+				"400": apiErrorResponse("The ID or the pagination is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+				"409": apiErrorResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/sarif", apiGetScanSARIF, operation{
+			Summary: "Export the findings of a scan that is done as a SARIF 2.1.0 log",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": sarifResponse("The SARIF log, ignored and dismissed findings are suppressed"),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+				"409": apiErrorResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/csv", apiGetScanCSV, operation{
+			Summary: "Export the findings of a scan that is done as CSV, one row per finding",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": fileResponse("The findings", csvContentType),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+				"409": apiErrorResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/xlsx", apiGetScanXLSX, operation{
+			Summary: "Export the findings of a scan that is done as an Excel workbook, one row per finding",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": fileResponse("The findings", xlsxContentType),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+				"409": apiErrorResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/report", apiGetScanReport, operation{
+			Summary: "Get a self-contained HTML report of a scan that is done",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": htmlResponse("The report, with the styles and fonts inlined"),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+				"409": apiErrorResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/pdf", apiGetScanPDF, operation{
+			Summary: "Get the report of a scan that is done as a PDF file",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": fileResponse("The report", pdfContentType),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+				"409": apiErrorResponse("The scan is not done"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/events", apiGetScanEvents, operation{
+			Summary: "Stream the status transitions of a scan as server-sent events until it is finished",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": eventStreamResponse("The current state of the scan followed by its transitions"),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/events", apiGetEvents, operation{
+			Summary: "Stream the status transitions of all scans as server-sent events",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": eventStreamResponse("The current state of the unfinished scans followed by the transitions of all scans"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/scans/:id/diff", apiGetScanDiff, operation{
+			Summary: "Compare the findings of a scan with an earlier scan",
+			Tags:    tagsAPI,
+			Parameters: []parameter{
+				queryParam("base", "The ID of the scan to compare with, defaults to the previous scan of the same codebase", schema{Type: "string", Format: "uuid"}),
+			},
+			Responses: map[string]response{
+				"200": jsonResponse("The new, resolved and persisting findings", ref("ScanDiff")),
+				"400": apiErrorResponse("An ID is invalid"),
+				"404": apiErrorResponse("A scan does not exist or there is no earlier scan"),
+				"409": apiErrorResponse("A scan is not done"),
+			},
+		}},
+		{http.MethodPost, "/api/v1/scans/:id/findings/:finding/triage", apiTriageFinding, operation{
+			Summary:     "Triage a finding of a scan, records the user of the token",
+			Tags:        tagsAPI,
+			RequestBody: formBody("application/x-www-form-urlencoded", triageFormFields, "status"),
+			Responses: map[string]response{
+				"200": jsonResponse("The triaged finding", ref("Finding")),
+				"400": apiErrorResponse("The ID, the status or the comment is invalid"),
+				"404": apiErrorResponse("The finding does not exist in the scan"),
+			},
+		}},
+		{http.MethodDelete, "/api/v1/scans/:id", apiDeleteScan, operation{
+			Summary: "Delete a scan, cancels it first if it is not finished",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"204": {Description: "The scan was deleted"},
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+			},
+		}},
+		{http.MethodPost, "/api/v1/scans/:id/cancel", apiCancelScan, operation{
+			Summary: "Cancel a queued or running scan",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"202": jsonResponse("The scan is being cancelled", ref("Scan")),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The scan does not exist"),
+				"409": apiErrorResponse("The scan is already finished"),
+			},
+		}},
+
+		{http.MethodGet, "/api/v1/projects", apiListProjects, operation{
+			Summary: "List projects with the findings of their latest scan",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": jsonResponse("The projects", schema{Type: "array", Items: &schema{Ref: "#/components/schemas/Project"}}),
+			},
+		}},
+		{http.MethodPost, "/api/v1/projects", apiNewProject, operation{
+			Summary:     "Create a project",
+			Tags:        tagsAPI,
+			RequestBody: formBody("application/x-www-form-urlencoded", projectFormFields, "name"),
+			Responses: map[string]response{
+				"201": jsonResponse("The project was created", ref("Project")),
+				"400": apiErrorResponse("The form is invalid"),
+				"409": apiErrorResponse("A project with the name already exists"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/projects/:id", apiGetProject, operation{
+			Summary: "Get a project with the findings of its latest scan",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": jsonResponse("The project", ref("Project")),
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The project does not exist"),
+			},
+		}},
+		{http.MethodPut, "/api/v1/projects/:id", apiUpdateProject, operation{
+			Summary:     "Replace the name, description and default rulesets of a project",
+			Tags:        tagsAPI,
+			RequestBody: formBody("application/x-www-form-urlencoded", projectFormFields, "name"),
+			Responses: map[string]response{
+				"200": jsonResponse("The changed project", ref("Project")),
+				"400": apiErrorResponse("The ID or the form is invalid"),
+				"404": apiErrorResponse("The project does not exist"),
+				"409": apiErrorResponse("A project with the name already exists"),
+			},
+		}},
+		{http.MethodDelete, "/api/v1/projects/:id", apiDeleteProject, operation{
+			Summary: "Delete a project, its scans are kept",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"204": {Description: "The project was deleted"},
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The project does not exist"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/rulesets", apiListRulesets, operation{
+			Summary:   "List the local, registry and custom rulesets that can be selected for a scan",
+			Tags:      tagsAPI,
+			Responses: map[string]response{"200": jsonResponse("The rulesets", schema{Type: "array", Items: &schema{Ref: "#/components/schemas/Ruleset"}})},
+		}},
+		{http.MethodPost, "/api/v1/rulesets", apiNewRuleset, operation{
+			Summary:     "Upload a custom ruleset, the rules are validated with Semgrep",
+			Tags:        tagsAPI,
+			RequestBody: formBody("multipart/form-data", rulesFormFields, "name", "file"),
+			Responses: map[string]response{
+				"201": jsonResponse("The ruleset was added", ref("Ruleset")),
+				"400": apiErrorResponse("The form is invalid or Semgrep rejected the rules"),
+				"409": apiErrorResponse("A ruleset with this name already exists"),
+			},
+		}},
+		{http.MethodPost, "/api/v1/rulesets/snapshot", apiSnapshotRuleset, operation{
+			Summary:     "Download a registry ruleset into the rules directory for offline use, replaces an existing snapshot, only for admins",
+			Tags:        tagsAPI,
+			RequestBody: formBody("application/x-www-form-urlencoded", snapshotFormFields, "name"),
+			Responses: map[string]response{
+				"201": jsonResponse("The snapshot was saved", ref("Ruleset")),
+				"400": apiErrorResponse("The name is not one of the registry rulesets"),
+				"403": apiErrorResponse("The authenticated user is not an admin"),
+				"404": apiErrorResponse("The registry ruleset does not exist"),
+				"409": apiErrorResponse("A local ruleset with this name exists that is not a snapshot"),
+				"502": apiErrorResponse("The registry returned invalid rules"),
+			},
+		}},
+		{http.MethodDelete, "/api/v1/rulesets/:id", apiDeleteRuleset, operation{
+			Summary: "Delete a custom ruleset",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"204": {Description: "The ruleset was deleted"},
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The ruleset does not exist"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/tokens", apiListTokens, operation{
+			Summary:   "List the API tokens of the authenticated user",
+			Tags:      tagsAPI,
+			Responses: map[string]response{"200": jsonResponse("The API tokens", schema{Type: "array", Items: &schema{Ref: "#/components/schemas/APIToken"}})},
+		}},
+		{http.MethodPost, "/api/v1/tokens", apiCreateToken, operation{
+			Summary:     "Create an API token, the token is only returned once",
+			Tags:        tagsAPI,
+			RequestBody: formBody("application/x-www-form-urlencoded", tokenFormFields, "name"),
+			Responses: map[string]response{
+				"201": jsonResponse("The token was created", ref("APIToken")),
+				"400": apiErrorResponse("The form is invalid"),
+			},
+		}},
+		{http.MethodDelete, "/api/v1/tokens/:id", apiRevokeToken, operation{
+			Summary: "Revoke an API token",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"204": {Description: "The token was revoked"},
+				"400": apiErrorResponse("The ID is invalid"),
+				"404": apiErrorResponse("The token does not exist"),
+			},
+		}},
+
+		{http.MethodGet, "/api/v1/webhooks", apiListWebhooks, operation{
+			Summary: "List the webhooks, only for admins",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": jsonResponse("The webhooks", schema{Type: "array", Items: &schema{Ref: "#/components/schemas/Webhook"}}),
+				"403": apiErrorResponse("The authenticated user is not an admin"),
+			},
+		}},
+		{http.MethodPost, "/api/v1/webhooks", apiCreateWebhook, operation{
+			Summary:     "Create a webhook, only for admins",
+			Tags:        tagsAPI,
+			RequestBody: formBody("application/x-www-form-urlencoded", webhookFormFields, "name", "url"),
+			Responses: map[string]response{
+				"201": jsonResponse("The webhook was created", ref("Webhook")),
+				"400": apiErrorResponse("The form is invalid"),
+				"403": apiErrorResponse("The authenticated user is not an admin"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/webhooks/:id", apiGetWebhook, operation{
+			Summary: "Get a webhook, only for admins",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": jsonResponse("The webhook", ref("Webhook")),
+				"400": apiErrorResponse("The ID is invalid"),
+				"403": apiErrorResponse("The authenticated user is not an admin"),
+				"404": apiErrorResponse("The webhook does not exist"),
+			},
+		}},
+		{http.MethodGet, "/api/v1/webhooks/:id/deliveries", apiListDeliveries, operation{
+			Summary: "List the latest deliveries of a webhook, newest first, only for admins",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": jsonResponse("The deliveries", schema{Type: "array", Items: &schema{Ref: "#/components/schemas/WebhookDelivery"}}),
+				"400": apiErrorResponse("The ID is invalid"),
+				"403": apiErrorResponse("The authenticated user is not an admin"),
+				"404": apiErrorResponse("The webhook does not exist"),
+			},
+		}},
+		{http.MethodPost, "/api/v1/webhooks/:id/test", apiTestWebhook, operation{
+			Summary: "Send a test event to a webhook, only for admins",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"200": jsonResponse("The delivery of the test event", ref("WebhookDelivery")),
+				"400": apiErrorResponse("The ID is invalid"),
+				"403": apiErrorResponse("The authenticated user is not an admin"),
+				"404": apiErrorResponse("The webhook does not exist"),
+			},
+		}},
+		{http.MethodDelete, "/api/v1/webhooks/:id", apiDeleteWebhook, operation{
+			Summary: "Delete a webhook and its delivery log, only for admins",
+			Tags:    tagsAPI,
+			Responses: map[string]response{
+				"204": {Description: "The webhook was deleted"},
+				"400": apiErrorResponse("The ID is invalid"),
+				"403": apiErrorResponse("The authenticated user is not an admin"),
+				"404": apiErrorResponse("The webhook does not exist"),
+			},
+		}},
+
+		// Monitoring
+		{http.MethodGet, "/healthz", getHealth, operation{
+			Summary:   "Check if the process is alive",
+			Tags:      tagsMon,
+			Public:    true,
+			Responses: map[string]response{"200": jsonResponse("The process is alive", ref("Health"))},
+		}},
+		{http.MethodGet, "/readyz", getReady, operation{
+			Summary: "Check if the database, Semgrep, the temp directory and the workers are ready to run scans",
+			Tags:    tagsMon,
+			Public:  true,
+			Responses: map[string]response{
+				"200": jsonResponse("All checks are ok", ref("Readiness")),
+				"503": jsonResponse("At least one check failed", ref("Readiness")),
+			},
+		}},
+		{http.MethodGet, "/metrics", getMetrics, operation{
+			Summary:   "Get the metrics in the Prometheus text format, public if BAGEL_METRICS_PUBLIC is set",
+			Tags:      tagsMon,
+			Public:    metricsPublic,
+			Responses: map[string]response{"200": {Description: "The metrics", Content: map[string]mediaType{metrics.ContentType: {Schema: schemaString}}}},
+		}},
+
+		// Specification
+		{http.MethodGet, "/openapi.json", getOpenAPI, operation{
+			Summary:   "Get this OpenAPI specification",
+			Tags:      tagsSpec,
+			Public:    true,
+			Responses: map[string]response{"200": jsonResponse("The OpenAPI specification", schema{Type: "object"})},
+		}},
+	}
+}
@@ -0,0 +1,267 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	errSnapshotAdminOnly = errors.New("only admins can create snapshots")
+)
+
+// apiRuleset is the JSON representation of a ruleset returned by the API
+type apiRuleset struct {
+	Name      string     `json:"name"`
+	Custom    bool       `json:"custom"`
+	Local     bool       `json:"local"`
+	ID        string     `json:"id,omitempty"`
+	Filename  string     `json:"filename,omitempty"`
+	RuleCount int        `json:"rule_count,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// newAPIRuleset converts a ruleset into its JSON representation
+func newAPIRuleset(info *semgrep.RulesetInfo) apiRuleset {
+	a := apiRuleset{
+		Name:      info.Name,
+		Custom:    info.Custom,
+		Local:     info.Local,
+		RuleCount: info.RuleCount,
+	}
+
+	// Leave out what is unknown for registry rulesets
+	if info.ID != uuid.Nil {
+		a.ID = info.ID.String()
+	}
+	if !info.UpdatedAt.IsZero() {
+		a.UpdatedAt = &info.UpdatedAt
+	}
+
+	return a
+}
+
+// newAPICustomRuleset converts a custom ruleset into its JSON representation
+func newAPICustomRuleset(custom *semgrep.CustomRuleset) apiRuleset {
+	return apiRuleset{
+		Name:      custom.Name,
+		Custom:    true,
+		ID:        custom.ID.String(),
+		Filename:  custom.Filename,
+		RuleCount: custom.RuleCount,
+		UpdatedAt: &custom.UploadDate,
+	}
+}
+
+// newAPILocalRuleset converts a local ruleset into its JSON representation
+func newAPILocalRuleset(local *semgrep.LocalRuleset) apiRuleset {
+	return apiRuleset{
+		Name:      local.Name,
+		Local:     true,
+		RuleCount: local.RuleCount,
+		UpdatedAt: &local.UpdatedAt,
+	}
+}
+
+// listRules displays the local and custom rulesets and the forms to upload new ones or snapshot the registry
+func listRules(c *gin.Context) {
+	custom, err := semgrep.ListCustomRulesets(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	local, err := semgrep.ListLocalRulesets()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	render(c, http.StatusOK, "rules.tmpl", gin.H{
+		"Title":           "Rules",
+		"CustomRulesets":  custom,
+		"LocalRulesets":   local,
+		"RegistryNames":   registryNames(),
+		"RulesetsOffline": semgrep.RulesOffline(),
+	})
+}
+
+// snapshotRules downloads a registry ruleset into the rules directory
+func snapshotRules(c *gin.Context) {
+	if _, status, err := createSnapshot(c); err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/rules")
+}
+
+// newRules accepts a POST request with a multipart form containing a name and a rules YAML file
+func newRules(c *gin.Context) {
+	if _, status, err := createRules(c); err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/rules")
+}
+
+// deleteRules removes a custom ruleset
+func deleteRules(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if err := semgrep.DeleteCustomRuleset(db, uuid.MustParse(id)); err != nil {
+		if errors.Is(err, semgrep.ErrRulesetNotFound) {
+			c.String(http.StatusNotFound, "Ruleset not found")
+			return
+		}
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/rules")
+}
+
+// createRules reads the uploaded rules, validates them with Semgrep and stores them.
+// Returns the HTTP status code to use together with the error if it fails
+func createRules(c *gin.Context) (custom *semgrep.CustomRuleset, status int, err error) {
+	name := SanitizeHTML(strings.TrimSpace(c.PostForm("name")))
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("File cannot be empty")
+	}
+	if file.Size > semgrep.MaxRulesSize {
+		return nil, http.StatusBadRequest, semgrep.ErrRulesTooLarge
+	}
+
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer fileReader.Close()
+
+	rules, err := io.ReadAll(io.LimitReader(fileReader, semgrep.MaxRulesSize+1))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	custom, err = semgrep.AddCustomRuleset(db, name, SanitizeHTML(file.Filename), rules)
+	if err != nil {
+		switch {
+		case errors.Is(err, semgrep.ErrRulesetExists):
+			return nil, http.StatusConflict, err
+		case errors.Is(err, semgrep.ErrInvalidRules), errors.Is(err, semgrep.ErrRulesTooLarge), errors.Is(err, semgrep.ErrEmptyRulesName):
+			return nil, http.StatusBadRequest, err
+		default:
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	return custom, http.StatusCreated, nil
+}
+
+// createSnapshot downloads the registry ruleset from the name field, only allowed for admins as it writes to the rules directory.
+// Returns the HTTP status code to use together with the error if it fails
+func createSnapshot(c *gin.Context) (local semgrep.LocalRuleset, status int, err error) {
+	if !currentUser(c).IsAdmin {
+		return local, http.StatusForbidden, errSnapshotAdminOnly
+	}
+
+	local, err = semgrep.SnapshotRegistry(strings.TrimSpace(c.PostForm("name")))
+	if err != nil {
+		switch {
+		case errors.Is(err, semgrep.ErrInvalidRulesetName):
+			return local, http.StatusBadRequest, err
+		case errors.Is(err, semgrep.ErrRulesetNotFound):
+			return local, http.StatusNotFound, err
+		case errors.Is(err, semgrep.ErrNotSnapshot):
+			return local, http.StatusConflict, err
+		case errors.Is(err, semgrep.ErrInvalidRules), errors.Is(err, semgrep.ErrRulesTooLarge):
+			return local, http.StatusBadGateway, err
+		default:
+			return local, http.StatusInternalServerError, err
+		}
+	}
+
+	return local, http.StatusCreated, nil
+}
+
+// registryNames returns the names of the registry rulesets, sorted
+func registryNames() (names []string) {
+	for name := range semgrep.Rulesets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// apiListRulesets lists the rulesets that can be selected for a scan
+func apiListRulesets(c *gin.Context) {
+	rulesets, err := semgrep.ListRulesets(db)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := []apiRuleset{}
+	for i := range rulesets {
+		list = append(list, newAPIRuleset(&rulesets[i]))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// apiSnapshotRuleset downloads a registry ruleset into the rules directory
+func apiSnapshotRuleset(c *gin.Context) {
+	local, status, err := createSnapshot(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newAPILocalRuleset(&local))
+}
+
+// apiNewRuleset uploads a custom ruleset, see newRules for the fields
+func apiNewRuleset(c *gin.Context) {
+	custom, status, err := createRules(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newAPICustomRuleset(custom))
+}
+
+// apiDeleteRuleset removes a custom ruleset
+func apiDeleteRuleset(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := semgrep.DeleteCustomRuleset(db, uuid.MustParse(id)); err != nil {
+		if errors.Is(err, semgrep.ErrRulesetNotFound) {
+			apiError(c, http.StatusNotFound, err)
+			return
+		}
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"bagel/internal/semgrep"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// The media type registered for SARIF logs
+	sarifContentType = "application/sarif+json"
+)
+
+// scanSARIF exports the scan from the id parameter as a SARIF log.
+// Returns the HTTP status code to use together with the error if it fails
+func scanSARIF(c *gin.Context) (scan *semgrep.Scan, b []byte, status int, err error) {
+	scan, status, err = exportScan(c)
+	if err != nil {
+		return nil, nil, status, err
+	}
+
+	b, err = scan.SARIF()
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	return scan, b, http.StatusOK, nil
+}
+
+// getScanSARIF downloads the findings of a scan as a SARIF file
+func getScanSARIF(c *gin.Context) {
+	scan, b, status, err := scanSARIF(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "bagel-"+scan.ID.String()+".sarif"))
+	c.Data(http.StatusOK, sarifContentType, b)
+}
+
+// apiGetScanSARIF returns the findings of a scan as a SARIF log
+func apiGetScanSARIF(c *gin.Context) {
+	_, b, status, err := scanSARIF(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.Data(http.StatusOK, sarifContentType, b)
+}
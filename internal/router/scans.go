@@ -1,13 +1,15 @@
 package router
 
 import (
-	"bagel/internal/logger"
+	"bagel/internal/random"
 	"bagel/internal/semgrep"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/gabriel-vasile/mimetype"
@@ -17,7 +19,15 @@ import (
 
 var (
 	// List of allowed MIME types for the uploaded files
-	allowedArchiveMIMETypes = []string{"application/zip", "application/gzip", "application/x-tar", "application/x-bzip2"}
+	allowedArchiveMIMETypes = []string{
+		"application/zip",
+		"application/gzip",
+		"application/x-tar",
+		"application/x-bzip2",
+		"application/x-xz",
+		"application/zstd",
+		"application/x-7z-compressed",
+	}
 )
 
 // listScans retrieves all scans from the database and displays them
@@ -25,87 +35,155 @@ func listScans(c *gin.Context) {
 	var scans []semgrep.Scan
 	db.Order("upload_date desc").Find(&scans)
 
-	c.HTML(http.StatusOK, "scans.tmpl", gin.H{"Scans": scans, "Rulesets": semgrep.Rulesets})
-}
-
-// newScan accepts a POST request with a multipart form containing a file, name and ruleset.
-// The file is saved to disk and the scan is added to the database
-func newScan(c *gin.Context) {
-	// Check if name is empty
-	name := c.PostForm("name")
-	if name == "" {
-		c.String(http.StatusBadRequest, "Name cannot be empty")
+	rulesets, err := semgrep.ListRulesets(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
 		return
 	}
 
-	// Check if ruleset is valid
-	rulesetStr := c.PostForm("ruleset")
-	ruleset, ok := semgrep.Rulesets[rulesetStr]
-	if !ok {
-		c.String(http.StatusBadRequest, "Invalid ruleset, must be one of '%s'", semgrep.Rulesets)
+	projects, err := semgrep.ListProjects(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
 		return
 	}
 
-	// Check if file is empty
-	file, err := c.FormFile("file")
-	if err != nil {
-		c.String(http.StatusBadRequest, "File cannot be empty")
-		return
+	// The names of the projects shown in the list of scans
+	projectNames := map[uuid.UUID]string{}
+	for _, project := range projects {
+		projectNames[project.ID] = project.Name
 	}
 
-	fileReader, err := file.Open()
-	if err != nil {
-		c.String(http.StatusInternalServerError, "%s", err)
+	render(c, http.StatusOK, "scans.tmpl", gin.H{
+		"Scans":        scans,
+		"Rulesets":     rulesets,
+		"Projects":     projects,
+		"ProjectNames": projectNames,
+		"Project":      c.Query("project"),
+	})
+}
+
+// newScan accepts a POST request with a multipart form containing a name, one or more rulesets and either a file or a Git URL.
+// The file is saved to disk or the repository is cloned later by the worker and the scan is added to the database
+func newScan(c *gin.Context) {
+	if _, status, err := createScan(c); err != nil {
+		c.String(status, "%s", err)
 		return
 	}
-	defer fileReader.Close()
 
-	// Check if its actually a supported archive
-	mtype, err := mimetype.DetectReader(fileReader)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "%s", err)
-		return
+	c.Redirect(http.StatusFound, "/")
+}
+
+// createScan validates the form of a new scan, saves the uploaded file and adds the scan to the queue.
+// Returns the HTTP status code to use together with the error if it fails
+func createScan(c *gin.Context) (scan *semgrep.Scan, status int, err error) {
+	// Check if name is empty
+	name := c.PostForm("name")
+	if name == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("Name cannot be empty")
 	}
-	if !slices.Contains(allowedArchiveMIMETypes, mtype.String()) {
-		c.String(http.StatusBadRequest, "Filetype must be one of '%s': %s detected as %s", allowedArchiveMIMETypes, file.Filename, mtype.String())
-		return
+
+	// The project is optional, its default rulesets are used if no ruleset is selected
+	var project *semgrep.Project
+	if projectID := strings.TrimSpace(c.PostForm("project")); projectID != "" {
+		if err := validateID(projectID); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		project, err = semgrep.FindProject(db, uuid.MustParse(projectID))
+		if err != nil {
+			if errors.Is(err, semgrep.ErrProjectNotFound) {
+				return nil, http.StatusBadRequest, err
+			}
+			return nil, http.StatusInternalServerError, err
+		}
 	}
 
-	// Generate a new UUID for the scan
-	// Do not use uuid.New() as it can panic
-	var id uuid.UUID
-	for {
-		id, err = uuid.NewRandom()
-		if err == nil {
-			break
+	rulesetNames := c.PostFormArray("ruleset")
+	if len(rulesetNames) == 0 && project != nil {
+		rulesetNames = project.Rulesets.Names()
+	}
+
+	// Check if the rulesets are valid, the field can be repeated
+	rulesets, err := semgrep.FindRulesets(db, rulesetNames)
+	if err != nil {
+		if errors.Is(err, semgrep.ErrRulesetNotFound) || errors.Is(err, semgrep.ErrNoRulesets) {
+			return nil, http.StatusBadRequest, err
 		}
+		return nil, http.StatusInternalServerError, err
 	}
 
-	uploadPath := path.Join(os.TempDir(), id.String()+mtype.Extension())
-	unpackPath := path.Join(os.TempDir(), id.String())
+	// Generate a new UUID for the scan
+	id := random.UUID()
 
-	scan := semgrep.Scan{
+	scan = &semgrep.Scan{
 		ID:           id,
 		ScanName:     SanitizeHTML(name),
-		Ruleset:      ruleset,
+		Rulesets:     rulesets,
 		UploadDate:   time.Now(),
-		UploadName:   SanitizeHTML(file.Filename),
-		UploadPath:   uploadPath,
-		UnpackedPath: unpackPath,
-		Finished:     false,
+		UnpackedPath: path.Join(os.TempDir(), id.String()),
+		RequestID:    c.GetString(contextRequestID),
+	}
+	if project != nil {
+		scan.ProjectID = project.ID
 	}
 
-	if err := c.SaveUploadedFile(file, scan.UploadPath); err != nil {
-		c.String(http.StatusInternalServerError, "%s", err)
-		return
+	if gitURL := strings.TrimSpace(c.PostForm("git_url")); gitURL != "" {
+		// Scan a Git repository, the worker clones it into the unpacked path
+		if err := semgrep.ValidateGitURL(gitURL); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		gitRef := strings.TrimSpace(c.PostForm("git_ref"))
+		if err := semgrep.ValidateGitRef(gitRef); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		scan.GitURL = gitURL
+		scan.GitRef = gitRef
+		scan.UploadName = SanitizeHTML(gitURL)
+	} else {
+		// Check if file is empty
+		file, err := c.FormFile("file")
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("File or Git URL cannot be empty")
+		}
+
+		fileReader, err := file.Open()
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		defer fileReader.Close()
+
+		// Check if its actually a supported archive
+		mtype, err := mimetype.DetectReader(fileReader)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if !slices.Contains(allowedArchiveMIMETypes, mtype.String()) {
+			return nil, http.StatusBadRequest, fmt.Errorf("Filetype must be one of '%s': %s detected as %s", allowedArchiveMIMETypes, file.Filename, mtype.String())
+		}
+
+		scan.UploadName = SanitizeHTML(file.Filename)
+		scan.UploadPath = path.Join(os.TempDir(), id.String()+mtype.Extension())
+
+		if err := c.SaveUploadedFile(file, scan.UploadPath); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		requestLog(c).Info("Saved file %s", scan.UploadPath)
 	}
-	logger.Info("Saved file %s", scan.UploadPath)
 
-	db.Create(&scan)
-	scan.AddToQueue()
-	logger.Info("Created and added scan %s to queue", scan.ID.String())
+	if err := scan.AddToQueue(db); err != nil {
+		// No worker will pick up the scan, so nothing else would remove the upload
+		if scan.UploadPath != "" {
+			if errRemove := os.Remove(scan.UploadPath); errRemove != nil {
+				requestLog(c).ErrorF("error removing file %s: %s", scan.UploadPath, errRemove)
+			}
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	requestLog(c).With("scan_id", scan.ID.String()).Info("Created and added scan to queue")
 
-	c.Redirect(http.StatusFound, "/")
+	return scan, http.StatusCreated, nil
 }
 
 // getScan retrieves a scan from the database and displays the results
@@ -125,21 +203,33 @@ func getScan(c *gin.Context) {
 		return
 	}
 
-	if !scan.Finished {
-		c.String(http.StatusForbidden, "Scan not finished")
-		return
-	}
-
-	// Only try to parse the Semgrep output if there was no error as with an error, there will likely be an unmarshalling error
-	if scan.Error == "" {
-		// Parse the Semgrep output into the struct temporarily
-		if err := scan.ParseResults(); err != nil {
+	// Scans that are not finished show their progress, scans with an error do not have findings
+	if scan.IsFinished() && scan.Error == "" {
+		if err := scan.LoadFindings(db); err != nil {
 			c.String(http.StatusInternalServerError, "%s", err)
 			return
 		}
 	}
 
-	c.HTML(http.StatusOK, "scan.tmpl", gin.H{"Title": scan.ScanName, "Scan": scan})
+	// The project is only linked on the page, so a deleted project is not an error
+	var project *semgrep.Project
+	if scan.ProjectID != uuid.Nil {
+		project, _ = semgrep.FindProject(db, scan.ProjectID)
+	}
+
+	// Only offer the comparison if there is something to compare with
+	var previous *semgrep.Scan
+	if scan.Status == semgrep.StatusDone {
+		previous, _ = scan.PreviousScan(db)
+	}
+
+	render(c, http.StatusOK, "scan.tmpl", gin.H{
+		"Title":          scan.ScanName,
+		"Scan":           &scan,
+		"Project":        project,
+		"PreviousScan":   previous,
+		"TriageStatuses": semgrep.TriageStatuses,
+	})
 }
 
 // getScanJSON retrieves a scan from the database and returns the Semgrep output as JSON
@@ -159,7 +249,7 @@ func getScanJSON(c *gin.Context) {
 		return
 	}
 
-	if !scan.Finished {
+	if !scan.IsFinished() {
 		c.String(http.StatusForbidden, "Scan not finished")
 		return
 	}
@@ -181,8 +271,40 @@ func deleteScan(c *gin.Context) {
 		return
 	}
 
-	// Delete scan from database
-	db.Delete(&semgrep.Scan{}, "id = ?", id)
+	// Stop the scan first so the worker does not keep running
+	if err := semgrep.Cancel(db, uuid.MustParse(id)); err != nil && !errors.Is(err, semgrep.ErrScanFinished) && !errors.Is(err, semgrep.ErrScanNotFound) {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	// Delete scan and findings from database
+	if err := semgrep.DeleteScan(db, uuid.MustParse(id)); err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// cancelScan cancels a queued or running scan
+func cancelScan(c *gin.Context) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		c.String(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if err := semgrep.Cancel(db, uuid.MustParse(id)); err != nil {
+		switch {
+		case errors.Is(err, semgrep.ErrScanNotFound):
+			c.String(http.StatusNotFound, "Scan not found")
+		case errors.Is(err, semgrep.ErrScanFinished):
+			c.String(http.StatusConflict, "Scan already finished")
+		default:
+			c.String(http.StatusInternalServerError, "%s", err)
+		}
+		return
+	}
 
 	c.Redirect(http.StatusFound, "/")
 }
@@ -0,0 +1,45 @@
+// The states of a scan as sent by the event streams, shown while waiting for the scan
+const scanStateLabels = {
+	queued: "Queued, please wait...",
+	unpacking: "Unpacking, please wait...",
+	scanning: "Scanning, please wait...",
+	parsing: "Parsing the results, please wait...",
+	done: "Finished",
+	failed: "Error",
+	cancelled: "Cancelled",
+};
+
+// Shows the state of a scan event in the .scan-state elements of its scan
+function showScanState(event) {
+	document.querySelectorAll(`.scan-state[data-id="${event.scan_id}"]`).forEach((element) => {
+		element.textContent = scanStateLabels[event.state] || event.state;
+	});
+}
+
+// Checks if the scan of an event reached a final status
+function isScanFinished(event) {
+	return ["done", "failed", "cancelled"].includes(event.status);
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+	// Buttons deleting the resource at their data-url, like tokens, users, rulesets and projects.
+	// Goes to data-redirect afterwards if set, otherwise reloads the page
+	document.querySelectorAll(".delete-button").forEach((button) => {
+		button.addEventListener("click", () => {
+			if (confirm("Are you sure?")) {
+				// Do not follow the redirect of the UI routes, it would be requested with DELETE as well
+				fetch(button.dataset.url, { method: "DELETE", redirect: "manual" }).then((response) => {
+					if (!response.ok && response.type !== "opaqueredirect") {
+						response.text().then((text) => alert(text));
+						return;
+					}
+					if (button.dataset.redirect) {
+						window.location.href = button.dataset.redirect;
+						return;
+					}
+					window.location.reload();
+				});
+			}
+		});
+	});
+});
@@ -1,4 +1,25 @@
 document.addEventListener("DOMContentLoaded", function () {
+	// Scans that are not finished show their progress until the results are available
+	const progress = document.getElementById("scan-progress");
+	if (progress) {
+		const source = new EventSource(`/scan/${progress.dataset.id}/events`);
+		source.addEventListener("message", (message) => {
+			const event = JSON.parse(message.data);
+			showScanState(event);
+			if (isScanFinished(event)) {
+				source.close();
+				window.location.reload();
+			}
+		});
+
+		progress.querySelector(".scan-cancel-button").addEventListener("click", () => {
+			if (confirm("Are you sure?")) {
+				fetch(`/scan/${progress.dataset.id}/cancel`, { method: "POST" });
+			}
+		});
+		return;
+	}
+
 	// Add a listener to the search input
 	const searchInput = document.getElementById("scan-results-search-input");
 	searchInput.addEventListener("input", function () {
@@ -29,7 +50,7 @@ document.addEventListener("DOMContentLoaded", function () {
 		filterResultsWithFilters();
 	});
 
-	// Get all vuln classes and paths, populate the filter list
+	// Get all vuln classes, rulesets, triage statuses and paths, populate the filter list
 	populateFilters();
 
 	// Add filter logic to filter elements
@@ -78,9 +99,13 @@ function populateFilters() {
 	const vulnClassList = document.getElementById(
 		"scan-results-filter-vulnclass"
 	);
+	const rulesetList = document.getElementById("scan-results-filter-ruleset");
+	const triageList = document.getElementById("scan-results-filter-triage");
 	const pathList = document.getElementById("scan-results-filter-path");
 
 	let vulnClasses = new Set();
+	let rulesets = new Set();
+	let triageStatuses = new Set();
 	let paths = new Set();
 
 	const results = document
@@ -96,6 +121,23 @@ function populateFilters() {
 				.forEach((vc) => vulnClasses.add(vc.trim()));
 		}
 
+		// Get rulesets, can be multiple separated by commas
+		const rulesetElement = result.querySelector(".scan-result-data-ruleset");
+		if (rulesetElement) {
+			rulesetElement.textContent
+				.split(",")
+				.filter((r) => r.trim() !== "")
+				.forEach((r) => rulesets.add(r.trim()));
+		}
+
+		// Get triage status
+		const triageElement = result.querySelector(
+			".scan-result-data-triage-status"
+		);
+		if (triageElement) {
+			triageStatuses.add(triageElement.textContent.trim());
+		}
+
 		// Get path
 		const pathElement = result.querySelector(".scan-result-data-path");
 		if (pathElement) {
@@ -105,9 +147,13 @@ function populateFilters() {
 
 	// Sort all sets alphabetically
 	vulnClasses = Array.from(vulnClasses).sort();
+	rulesets = Array.from(rulesets).sort();
+	triageStatuses = Array.from(triageStatuses).sort();
 	paths = Array.from(paths).sort();
 
 	createFilterElements(vulnClassList, vulnClasses, "vulnclass");
+	createFilterElements(rulesetList, rulesets, "ruleset");
+	createFilterElements(triageList, triageStatuses, "triage");
 	createFilterElements(pathList, paths, "path");
 }
 
@@ -145,6 +191,16 @@ function filterResultsWithFilters() {
 			".filter-vulnclass.scan-results-filter-element-selected"
 		)
 	).map((el) => el.innerText);
+	const activeRulesets = Array.from(
+		document.querySelectorAll(
+			".filter-ruleset.scan-results-filter-element-selected"
+		)
+	).map((el) => el.innerText);
+	const activeTriageStatuses = Array.from(
+		document.querySelectorAll(
+			".filter-triage.scan-results-filter-element-selected"
+		)
+	).map((el) => el.innerText);
 	const activePaths = Array.from(
 		document.querySelectorAll(
 			".filter-path.scan-results-filter-element-selected"
@@ -153,6 +209,13 @@ function filterResultsWithFilters() {
 
 	document.querySelectorAll(".scan-result").forEach((result) => {
 		const vulnClassText = result.querySelector("h3").innerText;
+		const rulesetTexts = result
+			.querySelector(".scan-result-data-ruleset")
+			.innerText.split(",")
+			.map((r) => r.trim());
+		const triageText = result
+			.querySelector(".scan-result-data-triage-status")
+			.innerText.trim();
 		const pathText = result.querySelector(
 			".scan-result-data-path"
 		).innerText;
@@ -160,10 +223,16 @@ function filterResultsWithFilters() {
 		const matchesVulnClass =
 			activeVulnClasses.length === 0 ||
 			activeVulnClasses.some((vc) => vulnClassText.includes(vc));
+		const matchesRuleset =
+			activeRulesets.length === 0 ||
+			activeRulesets.some((r) => rulesetTexts.includes(r));
+		const matchesTriage =
+			activeTriageStatuses.length === 0 ||
+			activeTriageStatuses.includes(triageText);
 		const matchesPath =
 			activePaths.length === 0 || activePaths.includes(pathText);
 
-		if (matchesVulnClass && matchesPath) {
+		if (matchesVulnClass && matchesRuleset && matchesTriage && matchesPath) {
 			result.style.display = "";
 		} else {
 			result.style.display = "none";
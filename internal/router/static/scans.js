@@ -1,7 +1,9 @@
 document.addEventListener("DOMContentLoaded", function () {
 	const fileDrop = document.getElementById("scan-form-file-drop");
 	const fileInput = document.getElementById("scan-form-file-input");
+	const gitURLInput = document.getElementById("scan-form-git-url-input");
 	const nameInput = document.getElementById("scan-form-name-input");
+	const projectInput = document.getElementById("scan-form-project-input");
 	const rulesetInput = document.getElementById("scan-form-ruleset-input");
 	const startButton = document.getElementById("scan-form-start-button");
 
@@ -37,14 +39,30 @@ document.addEventListener("DOMContentLoaded", function () {
 			var filename = fileInput.files[0].name;
 			fileDrop.querySelector("p").textContent = filename;
 			nameInput.value = filename;
+			nameInput.dataset.filename = filename;
+			addRulesetsToName();
 			updateButtonState();
 		}
 	});
 
+	// Use the repository name as the scan name when a Git URL is entered
+	gitURLInput.addEventListener("input", () => {
+		const repoName = gitURLInput.value
+			.trim()
+			.replace(/\/+$/, "")
+			.split(/[/:]/)
+			.pop()
+			.replace(/\.git$/, "");
+		if (repoName !== "") {
+			nameInput.value = repoName;
+		}
+		updateButtonState();
+	});
+
 	// Update the start button state when the form is updated
 	function updateButtonState() {
 		const isFormValid =
-			fileInput.files.length > 0 &&
+			(fileInput.files.length > 0 || gitURLInput.value.trim() !== "") &&
 			nameInput.value.trim() !== "" &&
 			rulesetInput.value !== "";
 		startButton.disabled = !isFormValid;
@@ -52,23 +70,71 @@ document.addEventListener("DOMContentLoaded", function () {
 
 	// Listen for input changes
 	nameInput.addEventListener("input", updateButtonState);
-	rulesetInput.addEventListener("change", 
-		// Add the ruleset to the scan name just before the last dot
-		function() {
-			const ruleset = rulesetInput.value;
-			const name = nameInput.value;
-			const lastDotIndex = name.lastIndexOf(".");
-			if (lastDotIndex !== -1) {
-				nameInput.value = name.substring(0, lastDotIndex) + "_" + ruleset + name.substring(lastDotIndex);
+	rulesetInput.addEventListener("change", () => {
+		addRulesetsToName();
+		updateButtonState();
+	});
+
+	// Select the default rulesets of the project
+	function selectProjectRulesets() {
+		const option = projectInput.selectedOptions[0];
+		const rulesets = JSON.parse(option ? option.dataset.rulesets : "[]");
+		if (rulesets.length === 0) {
+			return;
+		}
+
+		Array.from(rulesetInput.options).forEach((option) => {
+			option.selected = rulesets.includes(option.value);
+		});
+		addRulesetsToName();
+		updateButtonState();
+	}
+	projectInput.addEventListener("change", selectProjectRulesets);
+	selectProjectRulesets();
+
+	// Add the selected rulesets to the name of the uploaded file just before the last dot
+	function addRulesetsToName() {
+		const filename = nameInput.dataset.filename;
+		const lastDotIndex = filename ? filename.lastIndexOf(".") : -1;
+		if (lastDotIndex === -1) {
+			return;
+		}
+
+		const rulesets = Array.from(rulesetInput.selectedOptions).map((option) => option.value);
+		if (rulesets.length === 0) {
+			nameInput.value = filename;
+			return;
+		}
+		nameInput.value = filename.substring(0, lastDotIndex) + "_" + rulesets.join("+") + filename.substring(lastDotIndex);
+	}
+
+	// Update the status of the listed scans as they progress
+	if (document.querySelector("a.scan-unfinished")) {
+		const source = new EventSource("/events");
+		source.addEventListener("message", (message) => {
+			const event = JSON.parse(message.data);
+			showScanState(event);
+			if (!isScanFinished(event)) {
+				return;
 			}
-			updateButtonState();
-		},
-	);
 
-	// Prevent unfinished scans from being clicked
-	document.querySelectorAll("a.scan-unfinished").forEach((link) => {
-		link.addEventListener("click", function (event) {
+			const link = document.querySelector(`a.scan-list-entry[href="/scan/${event.scan_id}"]`);
+			if (link) {
+				link.classList.remove("scan-unfinished");
+				link.classList.toggle("scan-error", event.status === "failed");
+				link.querySelectorAll(".scan-cancel-button").forEach((button) => button.remove());
+			}
+		});
+	}
+
+	// Cancel queued or running scans
+	document.querySelectorAll(".scan-cancel-button").forEach((button) => {
+		button.addEventListener("click", function (event) {
 			event.preventDefault();
+			event.stopPropagation();
+			if (confirm("Are you sure?")) {
+				fetch(`/scan/${button.dataset.id}/cancel`, { method: "POST" }).then(() => window.location.reload());
+			}
 		});
 	});
 });
@@ -0,0 +1,303 @@
+package router
+
+import (
+	"bagel/internal/webhook"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Number of deliveries shown in the delivery log of a webhook
+	deliveryLogSize = 50
+)
+
+var (
+	errWebhooksAdminOnly = errors.New("only admins can manage webhooks")
+)
+
+// apiWebhook is the JSON representation of a webhook returned by the API
+type apiWebhook struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiDelivery is the JSON representation of a webhook delivery returned by the API
+type apiDelivery struct {
+	ID             string     `json:"id"`
+	ScanID         string     `json:"scan_id,omitempty"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	ResponseBody   string     `json:"response_body,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// newAPIWebhook converts a webhook into its JSON representation
+func newAPIWebhook(w *webhook.Webhook) apiWebhook {
+	return apiWebhook{ID: w.ID.String(), Name: w.Name, URL: w.URL, Secret: w.Secret, CreatedAt: w.CreatedAt}
+}
+
+// newAPIDelivery converts a webhook delivery into its JSON representation
+func newAPIDelivery(d *webhook.Delivery) apiDelivery {
+	a := apiDelivery{
+		ID:             d.ID.String(),
+		Event:          d.Event,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		ResponseBody:   d.ResponseBody,
+		Error:          d.Error,
+		CreatedAt:      d.CreatedAt,
+	}
+
+	// Leave out what is not set
+	if d.ScanID != uuid.Nil {
+		a.ScanID = d.ScanID.String()
+	}
+	if !d.DeliveredAt.IsZero() {
+		a.DeliveredAt = &d.DeliveredAt
+	}
+	if d.Status == webhook.DeliveryPending {
+		a.NextAttemptAt = &d.NextAttemptAt
+	}
+
+	return a
+}
+
+// listWebhooks displays all webhooks and the form to create a new one, only allowed for admins
+func listWebhooks(c *gin.Context) {
+	if !currentUser(c).IsAdmin {
+		c.String(http.StatusForbidden, "Only admins can manage webhooks")
+		return
+	}
+
+	webhooks, err := webhook.List(db)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	render(c, http.StatusOK, "webhooks.tmpl", gin.H{"Title": "Webhooks", "Webhooks": webhooks})
+}
+
+// newWebhook accepts a POST request with a form containing a name, a URL and an optional secret
+func newWebhook(c *gin.Context) {
+	w, status, err := createWebhook(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/webhooks/"+w.ID.String())
+}
+
+// getWebhook displays a webhook with its secret and delivery log
+func getWebhook(c *gin.Context) {
+	w, status, err := findWebhook(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	deliveries, err := w.Deliveries(db, deliveryLogSize)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	render(c, http.StatusOK, "webhook.tmpl", gin.H{"Title": w.Name, "Webhook": w, "Deliveries": deliveries})
+}
+
+// testWebhook sends a test event to a webhook and shows the result in the delivery log
+func testWebhook(c *gin.Context) {
+	w, status, err := findWebhook(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	if _, err := w.SendTest(db); err != nil {
+		c.String(http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/webhooks/"+w.ID.String())
+}
+
+// deleteWebhook removes a webhook together with its delivery log
+func deleteWebhook(c *gin.Context) {
+	status, err := removeWebhook(c)
+	if err != nil {
+		c.String(status, "%s", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/webhooks")
+}
+
+// apiListWebhooks lists all webhooks
+func apiListWebhooks(c *gin.Context) {
+	if !currentUser(c).IsAdmin {
+		apiError(c, http.StatusForbidden, errWebhooksAdminOnly)
+		return
+	}
+
+	webhooks, err := webhook.List(db)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := []apiWebhook{}
+	for i := range webhooks {
+		list = append(list, newAPIWebhook(&webhooks[i]))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// apiCreateWebhook creates a webhook from a form containing a name, a URL and an optional secret
+func apiCreateWebhook(c *gin.Context) {
+	w, status, err := createWebhook(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newAPIWebhook(w))
+}
+
+// apiGetWebhook returns a webhook
+func apiGetWebhook(c *gin.Context) {
+	w, status, err := findWebhook(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newAPIWebhook(w))
+}
+
+// apiListDeliveries returns the latest deliveries of a webhook, newest first
+func apiListDeliveries(c *gin.Context) {
+	w, status, err := findWebhook(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	deliveries, err := w.Deliveries(db, deliveryLogSize)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := []apiDelivery{}
+	for i := range deliveries {
+		list = append(list, newAPIDelivery(&deliveries[i]))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// apiTestWebhook sends a test event to a webhook and returns the delivery
+func apiTestWebhook(c *gin.Context) {
+	w, status, err := findWebhook(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	delivery, err := w.SendTest(db)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newAPIDelivery(delivery))
+}
+
+// apiDeleteWebhook removes a webhook together with its delivery log
+func apiDeleteWebhook(c *gin.Context) {
+	status, err := removeWebhook(c)
+	if err != nil {
+		apiError(c, status, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// createWebhook creates a webhook from the fields name, url and the optional secret, only allowed for admins.
+// Returns the HTTP status code to use together with the error if it fails
+func createWebhook(c *gin.Context) (w *webhook.Webhook, status int, err error) {
+	if !currentUser(c).IsAdmin {
+		return nil, http.StatusForbidden, errWebhooksAdminOnly
+	}
+
+	w, err = webhook.Create(db, SanitizeHTML(c.PostForm("name")), c.PostForm("url"), c.PostForm("secret"))
+	if err != nil {
+		if errors.Is(err, webhook.ErrEmptyName) || errors.Is(err, webhook.ErrInvalidURL) {
+			return nil, http.StatusBadRequest, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return w, http.StatusCreated, nil
+}
+
+// findWebhook loads the webhook from the id parameter, only allowed for admins.
+// Returns the HTTP status code to use together with the error if it fails
+func findWebhook(c *gin.Context) (w *webhook.Webhook, status int, err error) {
+	if !currentUser(c).IsAdmin {
+		return nil, http.StatusForbidden, errWebhooksAdminOnly
+	}
+
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	w, err = webhook.Find(db, uuid.MustParse(id))
+	if err != nil {
+		if errors.Is(err, webhook.ErrWebhookNotFound) {
+			return nil, http.StatusNotFound, err
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return w, http.StatusOK, nil
+}
+
+// removeWebhook deletes the webhook from the id parameter, only allowed for admins.
+// Returns the HTTP status code to use together with the error if it fails
+func removeWebhook(c *gin.Context) (status int, err error) {
+	if !currentUser(c).IsAdmin {
+		return http.StatusForbidden, errWebhooksAdminOnly
+	}
+
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := webhook.Delete(db, uuid.MustParse(id)); err != nil {
+		if errors.Is(err, webhook.ErrWebhookNotFound) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusNoContent, nil
+}
@@ -0,0 +1,486 @@
+package semgrep
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bagel/internal/config"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+var (
+	// Limits for unpacking archives to protect against zip bombs
+	maxUnpackedBytes    = config.Int64("BAGEL_UNPACK_MAX_BYTES", 1<<30) // 1 GiB
+	maxUnpackedFiles    = config.Int("BAGEL_UNPACK_MAX_FILES", 100000)
+	maxCompressionRatio = config.Int64("BAGEL_UNPACK_MAX_RATIO", 100)
+
+	ErrUnpackTooLarge     = errors.New("archive exceeds the limit of unpacked bytes")
+	ErrUnpackTooMany      = errors.New("archive exceeds the limit of files")
+	ErrUnpackRatio        = errors.New("archive exceeds the maximum compression ratio")
+	ErrUnpackUnsafePath   = errors.New("archive contains an unsafe path")
+	ErrUnpackUnsafeLink   = errors.New("archive contains a link pointing outside of the archive")
+	errUnpackLimitReached = errors.New("limit reached")
+)
+
+const (
+	// The compression ratio is only enforced after this many bytes were unpacked,
+	// so small and highly compressible archives can still be scanned
+	ratioThreshold = 1 << 20 // 1 MiB
+
+	// Maximum length of a symlink target stored inside a zip or 7z file
+	maxLinkTarget = 4096
+
+	// Size of a tar header block
+	tarBlockSize = 512
+)
+
+// extractor unpacks archives into root while enforcing the unpack limits
+type extractor struct {
+	ctx         context.Context // Unpacking stops once the context is done
+	root        string          // The directory to unpack into
+	archiveSize int64           // The size of the archive on disk, used for the compression ratio
+	files       int             // The number of files unpacked so far
+	written     int64           // The number of bytes unpacked so far
+	err         error           // The limit that was hit while writing, if any
+}
+
+// newExtractor returns an extractor for the archive at archivePath unpacking into root
+func newExtractor(ctx context.Context, archivePath string, root string) (e *extractor, err error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+
+	return &extractor{ctx: ctx, root: root, archiveSize: info.Size()}, nil
+}
+
+// extractZip unpacks the zip file at archivePath
+func (e *extractor) extractZip(archivePath string) (err error) {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+
+	for _, f := range zipReader.File {
+		// Some Windows tools use backslashes as separators
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+
+		// Fail early on the declared size, the actual size is enforced while writing
+		if f.UncompressedSize64 > uint64(maxUnpackedBytes) {
+			return fmt.Errorf("%w of %d: %s", ErrUnpackTooLarge, maxUnpackedBytes, name)
+		}
+
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			if err := e.mkdir(name); err != nil {
+				return err
+			}
+
+		case mode&fs.ModeSymlink != 0:
+			target, err := readLink(f.Open)
+			if err != nil {
+				return err
+			}
+			if err := e.symlink(name, target); err != nil {
+				return err
+			}
+
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = e.writeFile(name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readLink reads the target of a symlink stored as the content of an archive entry
+func readLink(open func() (io.ReadCloser, error)) (target string, err error) {
+	rc, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(io.LimitReader(rc, maxLinkTarget))
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// extractTar unpacks the tar stream from r
+func (e *extractor) extractTar(r io.Reader) (err error) {
+	tarReader := tar.NewReader(r)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := e.mkdir(header.Name); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := e.writeFile(header.Name, tarReader); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := e.symlink(header.Name, header.Linkname); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			if err := e.hardlink(header.Name, header.Linkname); err != nil {
+				return err
+			}
+
+		default:
+			// Skip devices, FIFOs and other special files
+			continue
+		}
+	}
+}
+
+// path validates the name of an archive entry and returns the absolute path inside the root.
+// Returns an empty path for entries that refer to the root itself
+func (e *extractor) path(name string) (fullPath string, err error) {
+	if path.IsAbs(name) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("%w, absolute path: %s", ErrUnpackUnsafePath, name)
+	}
+
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", nil
+	}
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("%w, path traversal: %s", ErrUnpackUnsafePath, name)
+	}
+
+	// Never write through a symlink created by an earlier entry
+	parts := strings.Split(clean, "/")
+	current := e.root
+	for _, part := range parts {
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w, path through symlink: %s", ErrUnpackUnsafePath, name)
+		}
+	}
+
+	return filepath.Join(e.root, filepath.FromSlash(clean)), nil
+}
+
+// count counts a new file against the file limit
+func (e *extractor) count() (err error) {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+
+	e.files++
+	if e.files > maxUnpackedFiles {
+		return fmt.Errorf("%w of %d", ErrUnpackTooMany, maxUnpackedFiles)
+	}
+
+	return nil
+}
+
+// mkdir creates the directory for an archive entry
+func (e *extractor) mkdir(name string) (err error) {
+	fullPath, err := e.path(name)
+	if err != nil || fullPath == "" {
+		return err
+	}
+
+	return os.MkdirAll(fullPath, 0o750)
+}
+
+// writeFile writes the contents of an archive entry to disk
+func (e *extractor) writeFile(name string, r io.Reader) (err error) {
+	fullPath, err := e.path(name)
+	if err != nil || fullPath == "" {
+		return err
+	}
+
+	if err := e.count(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) // #nosec G304, path is validated above
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, &limitedReader{r: r, e: e})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, errUnpackLimitReached) {
+		return e.err
+	}
+
+	return err
+}
+
+// symlink creates a symlink for an archive entry if the target stays inside the root
+func (e *extractor) symlink(name string, target string) (err error) {
+	fullPath, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if fullPath == "" {
+		return fmt.Errorf("%w: %s", ErrUnpackUnsafeLink, name)
+	}
+
+	if err := e.count(); err != nil {
+		return err
+	}
+
+	resolved := filepath.Join(filepath.Dir(fullPath), filepath.FromSlash(target))
+	if filepath.IsAbs(target) || !isInside(e.root, resolved) {
+		return fmt.Errorf("%w: %s -> %s", ErrUnpackUnsafeLink, name, target)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o750); err != nil {
+		return err
+	}
+
+	return os.Symlink(target, fullPath)
+}
+
+// hardlink creates a hard link for an archive entry if the target stays inside the root
+func (e *extractor) hardlink(name string, target string) (err error) {
+	fullPath, err := e.path(name)
+	if err != nil {
+		return err
+	}
+
+	targetPath, err := e.path(target)
+	if err != nil {
+		return fmt.Errorf("%w: %s -> %s", ErrUnpackUnsafeLink, name, target)
+	}
+	if fullPath == "" || targetPath == "" {
+		return fmt.Errorf("%w: %s -> %s", ErrUnpackUnsafeLink, name, target)
+	}
+
+	if err := e.count(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o750); err != nil {
+		return err
+	}
+
+	return os.Link(targetPath, fullPath)
+}
+
+// verifySymlinks resolves all unpacked symlinks and fails if one of them
+// points outside of the root through a chain of other symlinks
+func (e *extractor) verifySymlinks() (err error) {
+	root, err := filepath.EvalSymlinks(e.root)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(e.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		resolved, err := filepath.EvalSymlinks(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Dangling symlinks can't be followed, remove them anyway
+			return os.Remove(p)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !isInside(root, resolved) {
+			rel, _ := filepath.Rel(e.root, p)
+			return fmt.Errorf("%w: %s", ErrUnpackUnsafeLink, rel)
+		}
+
+		return nil
+	})
+}
+
+// isInside checks if p is root or inside of root
+func isInside(root string, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+
+	return rel == "." || filepath.IsLocal(rel)
+}
+
+// limitedReader wraps a reader of an archive entry and enforces the byte and ratio limits
+type limitedReader struct {
+	r io.Reader
+	e *extractor
+}
+
+// Read reads from the underlying reader and fails once a limit is exceeded
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	n, err = l.r.Read(p)
+	l.e.written += int64(n)
+
+	if l.e.written > maxUnpackedBytes {
+		l.e.err = fmt.Errorf("%w of %d", ErrUnpackTooLarge, maxUnpackedBytes)
+		return n, errUnpackLimitReached
+	}
+
+	if l.e.written > ratioThreshold && l.e.archiveSize > 0 && l.e.written/l.e.archiveSize > maxCompressionRatio {
+		l.e.err = fmt.Errorf("%w of %d", ErrUnpackRatio, maxCompressionRatio)
+		return n, errUnpackLimitReached
+	}
+
+	return n, err
+}
+
+// extractCompressed decompresses the file at archivePath with decompress (if not nil) and unpacks it as a tar file.
+// If the decompressed data is not a tar file, it is written as a single file called name
+func (e *extractor) extractCompressed(archivePath string, name string, decompress func(io.Reader) (io.Reader, error)) (err error) {
+	f, err := os.Open(archivePath) // #nosec G304, archivePath does not contain user controllable data
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if decompress != nil {
+		if r, err = decompress(f); err != nil {
+			return err
+		}
+		if closer, ok := r.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+
+	// Use the original file name stored in the gzip header if there is one
+	name = singleFileName(name)
+	if gzipReader, ok := r.(*gzip.Reader); ok && gzipReader.Name != "" {
+		name = path.Base(strings.ReplaceAll(gzipReader.Name, "\\", "/"))
+	}
+
+	// Peek at the first block to check if this is a tar file
+	bufReader := bufio.NewReaderSize(r, tarBlockSize)
+	head, err := bufReader.Peek(tarBlockSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if decompress == nil || mimetype.Detect(head).Is("application/x-tar") {
+		return e.extractTar(bufReader)
+	}
+
+	return e.writeFile(name, bufReader)
+}
+
+// extract7z unpacks the 7z file at archivePath
+func (e *extractor) extract7z(archivePath string) (err error) {
+	sevenZipReader, err := sevenzip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer sevenZipReader.Close()
+
+	for _, f := range sevenZipReader.File {
+		// 7z files created on Windows use backslashes as separators
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+
+		// Fail early on the declared size, the actual size is enforced while writing
+		if f.UncompressedSize > uint64(maxUnpackedBytes) {
+			return fmt.Errorf("%w of %d: %s", ErrUnpackTooLarge, maxUnpackedBytes, name)
+		}
+
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			if err := e.mkdir(name); err != nil {
+				return err
+			}
+
+		case mode&fs.ModeSymlink != 0:
+			target, err := readLink(f.Open)
+			if err != nil {
+				return err
+			}
+			if err := e.symlink(name, target); err != nil {
+				return err
+			}
+
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = e.writeFile(name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// singleFileName returns the name for a compressed file that is not a tar file
+// by removing the compression extension from its base name
+func singleFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+
+	return name
+}
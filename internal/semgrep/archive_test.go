@@ -0,0 +1,618 @@
+package semgrep
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// testEntry is an entry of an archive built by a test
+type testEntry struct {
+	name string
+	body string
+	link string // The target of symlinks and hard links
+	typ  byte   // One of the tar type flags, tar.TypeReg if not set
+}
+
+// writeTar writes the entries as a tar file
+func writeTar(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typ, Linkname: entry.link, Mode: 0o644}
+		switch entry.typ {
+		case 0, tar.TypeReg:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(entry.body))
+		case tar.TypeDir:
+			header.Mode = 0o755
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeZip writes the entries as a zip file, symlinks store their target as the content
+func writeZip(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		body := entry.body
+		switch entry.typ {
+		case tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | 0o777)
+			body = entry.link
+		case tar.TypeDir:
+			header.SetMode(fs.ModeDir | 0o755)
+		default:
+			header.SetMode(0o644)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setLimit changes an unpack limit for the duration of the test
+func setLimit[T int | int64](t *testing.T, limit *T, value T) {
+	previous := *limit
+	*limit = value
+	t.Cleanup(func() { *limit = previous })
+}
+
+// listFiles returns the paths of all files and directories below dir, except for skip
+func listFiles(t *testing.T, dir string, skip string) (paths []string) {
+	t.Helper()
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == skip {
+			return filepath.SkipDir
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return paths
+}
+
+// TestUnpackMalicious checks that archives escaping the destination or exceeding the limits are rejected
+// without writing anything outside of the destination
+func TestUnpackMalicious(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string // zip or tar
+		entries func(outside string) []testEntry
+		limits  func(t *testing.T)
+		err     error
+	}{
+		{
+			name:    "zip path traversal",
+			format:  "zip",
+			entries: func(string) []testEntry { return []testEntry{{name: "../outside/evil.py", body: "evil"}} },
+			err:     ErrUnpackUnsafePath,
+		},
+		{
+			name:    "tar path traversal",
+			format:  "tar",
+			entries: func(string) []testEntry { return []testEntry{{name: "src/../../outside/evil.py", body: "evil"}} },
+			err:     ErrUnpackUnsafePath,
+		},
+		{
+			name:   "zip absolute path",
+			format: "zip",
+			entries: func(outside string) []testEntry {
+				return []testEntry{{name: filepath.ToSlash(filepath.Join(outside, "evil.py")), body: "evil"}}
+			},
+			err: ErrUnpackUnsafePath,
+		},
+		{
+			name:   "tar absolute path",
+			format: "tar",
+			entries: func(outside string) []testEntry {
+				return []testEntry{{name: filepath.ToSlash(filepath.Join(outside, "evil.py")), body: "evil"}}
+			},
+			err: ErrUnpackUnsafePath,
+		},
+		{
+			name:   "tar symlink outside and a file written through it",
+			format: "tar",
+			entries: func(string) []testEntry {
+				return []testEntry{
+					{name: "link", link: "../outside", typ: tar.TypeSymlink},
+					{name: "link/evil.py", body: "evil"},
+				}
+			},
+			err: ErrUnpackUnsafeLink,
+		},
+		{
+			name:   "zip symlink outside and a file written through it",
+			format: "zip",
+			entries: func(string) []testEntry {
+				return []testEntry{
+					{name: "link", link: "../outside", typ: tar.TypeSymlink},
+					{name: "link/evil.py", body: "evil"},
+				}
+			},
+			err: ErrUnpackUnsafeLink,
+		},
+		{
+			name:   "tar absolute symlink",
+			format: "tar",
+			entries: func(outside string) []testEntry {
+				return []testEntry{{name: "link", link: outside, typ: tar.TypeSymlink}}
+			},
+			err: ErrUnpackUnsafeLink,
+		},
+		{
+			name:   "tar file written through a symlink inside",
+			format: "tar",
+			entries: func(string) []testEntry {
+				return []testEntry{
+					{name: "src/", typ: tar.TypeDir},
+					{name: "link", link: "src", typ: tar.TypeSymlink},
+					{name: "link/evil.py", body: "evil"},
+				}
+			},
+			err: ErrUnpackUnsafePath,
+		},
+		{
+			name:   "tar symlink chain leaving the destination",
+			format: "tar",
+			entries: func(string) []testEntry {
+				return []testEntry{
+					{name: "here", link: ".", typ: tar.TypeSymlink},
+					{name: "up", link: "here/..", typ: tar.TypeSymlink},
+				}
+			},
+			err: ErrUnpackUnsafeLink,
+		},
+		{
+			name:   "tar hardlink outside",
+			format: "tar",
+			entries: func(string) []testEntry {
+				return []testEntry{{name: "link", link: "../outside/secret.txt", typ: tar.TypeLink}}
+			},
+			err: ErrUnpackUnsafeLink,
+		},
+		{
+			name:   "tar absolute hardlink",
+			format: "tar",
+			entries: func(outside string) []testEntry {
+				return []testEntry{{name: "link", link: filepath.Join(outside, "secret.txt"), typ: tar.TypeLink}}
+			},
+			err: ErrUnpackUnsafeLink,
+		},
+		{
+			name:   "tar hardlink to a symlink",
+			format: "tar",
+			entries: func(string) []testEntry {
+				return []testEntry{
+					{name: "a.py", body: "a"},
+					{name: "link", link: "a.py", typ: tar.TypeSymlink},
+					{name: "hard", link: "link", typ: tar.TypeLink},
+				}
+			},
+			err: ErrUnpackUnsafeLink,
+		},
+		{
+			name:   "too many files",
+			format: "tar",
+			entries: func(string) []testEntry {
+				return []testEntry{{name: "a.py"}, {name: "b.py"}, {name: "c.py"}, {name: "d.py"}}
+			},
+			limits: func(t *testing.T) { setLimit(t, &maxUnpackedFiles, 3) },
+			err:    ErrUnpackTooMany,
+		},
+		{
+			name:   "too many bytes",
+			format: "tar",
+			entries: func(string) []testEntry {
+				return []testEntry{{name: "a.py", body: strings.Repeat("a", 600)}, {name: "b.py", body: strings.Repeat("b", 600)}}
+			},
+			limits: func(t *testing.T) { setLimit(t, &maxUnpackedBytes, 1000) },
+			err:    ErrUnpackTooLarge,
+		},
+		{
+			name:   "too many bytes declared in zip",
+			format: "zip",
+			entries: func(string) []testEntry {
+				return []testEntry{{name: "a.py", body: strings.Repeat("a", 2000)}}
+			},
+			limits: func(t *testing.T) { setLimit(t, &maxUnpackedBytes, 1000) },
+			err:    ErrUnpackTooLarge,
+		},
+		{
+			name:   "compression ratio bomb",
+			format: "zip",
+			entries: func(string) []testEntry {
+				return []testEntry{{name: "bomb.py", body: strings.Repeat("\x00", 8<<20)}}
+			},
+			err: ErrUnpackRatio,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limits != nil {
+				tt.limits(t)
+			}
+
+			dir := t.TempDir()
+			outside := filepath.Join(dir, "outside")
+			if err := os.Mkdir(outside, 0o750); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			s := Scan{UploadPath: filepath.Join(dir, "upload."+tt.format), UploadName: "upload." + tt.format, UnpackedPath: filepath.Join(dir, "dest")}
+			if tt.format == "zip" {
+				writeZip(t, s.UploadPath, tt.entries(outside))
+			} else {
+				writeTar(t, s.UploadPath, tt.entries(outside))
+			}
+			before := listFiles(t, dir, s.UnpackedPath)
+
+			err := s.unpack(context.Background())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+
+			if after := listFiles(t, dir, s.UnpackedPath); !slices.Equal(before, after) {
+				t.Errorf("files outside of the destination changed from %v to %v", before, after)
+			}
+			if b, err := os.ReadFile(filepath.Join(outside, "secret.txt")); err != nil || string(b) != "secret" {
+				t.Errorf("file outside of the destination was changed: %q, %v", b, err)
+			}
+		})
+	}
+}
+
+// The files of the archives built for the format tests
+var testFiles = map[string]string{
+	"src/app.py":      "eval(input())\n",
+	"src/lib/util.py": "print(1)\n",
+}
+
+// sortedNames returns the names of the files in a stable order
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// testTar returns the test files as a tar file
+func testTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "files.tar")
+	var entries []testEntry
+	for _, name := range sortedNames(files) {
+		entries = append(entries, testEntry{name: name, body: files[name]})
+	}
+	writeTar(t, path, entries)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// compress compresses b with the writer returned by newWriter
+func compress(t *testing.T, b []byte, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	w, err := newWriter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Bytes()
+}
+
+// The writers of the compression formats, bzip2 has no writer in Go so its fixtures are in testdata
+var (
+	gzipWriter = func(name string) func(io.Writer) (io.WriteCloser, error) {
+		return func(w io.Writer) (io.WriteCloser, error) {
+			gw := gzip.NewWriter(w)
+			gw.Name = name
+			return gw, nil
+		}
+	}
+	xzWriter  = func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }
+	zstWriter = func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+)
+
+// write7z writes the files as a 7z file without compression, which is enough to test the unpacking
+func write7z(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	names := sortedNames(files)
+
+	var packed bytes.Buffer
+	for _, name := range names {
+		packed.WriteString(files[name])
+	}
+
+	// Numbers are stored with the number of extra bytes as leading one bits of the first byte
+	var header bytes.Buffer
+	number := func(v uint64) {
+		for n := 0; n < 8; n++ {
+			if v < 1<<(7*(n+1)) {
+				header.WriteByte(byte(0xff<<(8-n)) | byte(v>>(8*n)))
+				for i := 0; i < n; i++ {
+					header.WriteByte(byte(v >> (8 * i)))
+				}
+				return
+			}
+		}
+		header.WriteByte(0xff)
+		header.Write(binary.LittleEndian.AppendUint64(nil, v))
+	}
+
+	header.WriteByte(0x01) // Header
+	header.WriteByte(0x04) // MainStreamsInfo
+	header.WriteByte(0x06) // PackInfo with one stream at position 0
+	number(0)
+	number(1)
+	header.WriteByte(0x09)
+	number(uint64(packed.Len()))
+	header.WriteByte(0x00)
+	header.WriteByte(0x07) // UnpackInfo with one folder using the copy coder
+	header.WriteByte(0x0b)
+	number(1)
+	header.WriteByte(0x00)
+	number(1)
+	header.Write([]byte{0x01, 0x00})
+	header.WriteByte(0x0c)
+	number(uint64(packed.Len()))
+	header.WriteByte(0x00)
+	header.WriteByte(0x08) // SubStreamsInfo splitting the folder into the files
+	header.WriteByte(0x0d)
+	number(uint64(len(names)))
+	header.WriteByte(0x09)
+	for _, name := range names[:len(names)-1] {
+		number(uint64(len(files[name])))
+	}
+	header.WriteByte(0x0a) // The CRC of every file
+	header.WriteByte(0x01)
+	for _, name := range names {
+		header.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte(files[name]))))
+	}
+	header.WriteByte(0x00)
+	header.WriteByte(0x00)
+	header.WriteByte(0x05) // FilesInfo with the names as UTF-16
+	number(uint64(len(names)))
+	var fileNames bytes.Buffer
+	fileNames.WriteByte(0x00)
+	for _, name := range names {
+		for _, r := range utf16.Encode([]rune(name)) {
+			fileNames.Write(binary.LittleEndian.AppendUint16(nil, r))
+		}
+		fileNames.Write([]byte{0, 0})
+	}
+	header.WriteByte(0x11)
+	number(uint64(fileNames.Len()))
+	header.Write(fileNames.Bytes())
+	header.WriteByte(0x00)
+	header.WriteByte(0x00)
+
+	start := binary.LittleEndian.AppendUint64(nil, uint64(packed.Len()))
+	start = binary.LittleEndian.AppendUint64(start, uint64(header.Len()))
+	start = binary.LittleEndian.AppendUint32(start, crc32.ChecksumIEEE(header.Bytes()))
+
+	var b bytes.Buffer
+	b.Write([]byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c, 0x00, 0x04})
+	b.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(start)))
+	b.Write(start)
+	b.Write(packed.Bytes())
+	b.Write(header.Bytes())
+
+	return b.Bytes()
+}
+
+// readTestdata reads a file of the testdata directory
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// TestUnpackFormats checks that every supported format unpacks to the expected files
+func TestUnpackFormats(t *testing.T) {
+	single := map[string]string{"app.py": "eval(input())\n"}
+
+	tests := []struct {
+		name       string
+		uploadName string
+		archive    func(t *testing.T) []byte
+		files      map[string]string
+	}{
+		{"zip", "src.zip", func(t *testing.T) []byte {
+			path := filepath.Join(t.TempDir(), "src.zip")
+			writeZip(t, path, []testEntry{{name: "src/app.py", body: testFiles["src/app.py"]}, {name: "src/lib/util.py", body: testFiles["src/lib/util.py"]}})
+			b, _ := os.ReadFile(path)
+			return b
+		}, testFiles},
+		{"tar", "src.tar", func(t *testing.T) []byte { return testTar(t, testFiles) }, testFiles},
+		{"tar.gz", "src.tar.gz", func(t *testing.T) []byte { return compress(t, testTar(t, testFiles), gzipWriter("")) }, testFiles},
+		{"tar.bz2", "src.tar.bz2", func(t *testing.T) []byte { return readTestdata(t, "src.tar.bz2") }, testFiles},
+		{"tar.xz", "src.tar.xz", func(t *testing.T) []byte { return compress(t, testTar(t, testFiles), xzWriter) }, testFiles},
+		{"tar.zst", "src.tar.zst", func(t *testing.T) []byte { return compress(t, testTar(t, testFiles), zstWriter) }, testFiles},
+		{"7z", "src.7z", func(t *testing.T) []byte { return write7z(t, testFiles) }, testFiles},
+		{"single file gz with the name in the header", "upload.gz", func(t *testing.T) []byte {
+			return compress(t, []byte(single["app.py"]), gzipWriter("app.py"))
+		}, single},
+		{"single file gz named after the upload", "app.py.gz", func(t *testing.T) []byte {
+			return compress(t, []byte(single["app.py"]), gzipWriter(""))
+		}, single},
+		{"single file bz2", "app.py.bz2", func(t *testing.T) []byte { return readTestdata(t, "app.py.bz2") }, single},
+		{"single file xz", "app.py.xz", func(t *testing.T) []byte { return compress(t, []byte(single["app.py"]), xzWriter) }, single},
+		{"single file zst", "app.py.zst", func(t *testing.T) []byte { return compress(t, []byte(single["app.py"]), zstWriter) }, single},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := Scan{UploadPath: filepath.Join(dir, "upload"), UploadName: tt.uploadName, UnpackedPath: filepath.Join(dir, "dest")}
+			if err := os.WriteFile(s.UploadPath, tt.archive(t), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.unpack(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			unpacked := map[string]string{}
+			err := filepath.WalkDir(s.UnpackedPath, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				b, err := os.ReadFile(p)
+				rel, _ := filepath.Rel(s.UnpackedPath, p)
+				unpacked[filepath.ToSlash(rel)] = string(b)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(unpacked, tt.files) {
+				t.Errorf("expected %v, got %v", tt.files, unpacked)
+			}
+		})
+	}
+}
+
+// TestUnpackFormatsLimits checks that the limits apply to the decompressed data of every format
+func TestUnpackFormatsLimits(t *testing.T) {
+	large := map[string]string{"src/large.py": strings.Repeat("x = 1\n", 1000)}
+	zeros := map[string]string{"src/bomb.py": strings.Repeat("\x00", 8<<20)}
+
+	tests := []struct {
+		name       string
+		uploadName string
+		archive    func(t *testing.T) []byte
+		maxBytes   int64
+		err        error
+	}{
+		{"tar.gz too large", "src.tar.gz", func(t *testing.T) []byte { return compress(t, testTar(t, large), gzipWriter("")) }, 1000, ErrUnpackTooLarge},
+		{"tar.xz too large", "src.tar.xz", func(t *testing.T) []byte { return compress(t, testTar(t, large), xzWriter) }, 1000, ErrUnpackTooLarge},
+		{"tar.zst too large", "src.tar.zst", func(t *testing.T) []byte { return compress(t, testTar(t, large), zstWriter) }, 1000, ErrUnpackTooLarge},
+		{"tar.bz2 too large", "src.tar.bz2", func(t *testing.T) []byte { return readTestdata(t, "src.tar.bz2") }, 10, ErrUnpackTooLarge},
+		{"7z too large", "src.7z", func(t *testing.T) []byte { return write7z(t, large) }, 1000, ErrUnpackTooLarge},
+		{"single file gz too large", "large.py.gz", func(t *testing.T) []byte {
+			return compress(t, []byte(large["src/large.py"]), gzipWriter(""))
+		}, 1000, ErrUnpackTooLarge},
+		{"tar.gz bomb", "src.tar.gz", func(t *testing.T) []byte { return compress(t, testTar(t, zeros), gzipWriter("")) }, 0, ErrUnpackRatio},
+		{"tar.xz bomb", "src.tar.xz", func(t *testing.T) []byte { return compress(t, testTar(t, zeros), xzWriter) }, 0, ErrUnpackRatio},
+		{"tar.zst bomb", "src.tar.zst", func(t *testing.T) []byte { return compress(t, testTar(t, zeros), zstWriter) }, 0, ErrUnpackRatio},
+		{"single file bz2 bomb", "bomb.bz2", func(t *testing.T) []byte { return readTestdata(t, "bomb.bz2") }, 0, ErrUnpackRatio},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxBytes > 0 {
+				setLimit(t, &maxUnpackedBytes, tt.maxBytes)
+			}
+
+			dir := t.TempDir()
+			s := Scan{UploadPath: filepath.Join(dir, "upload"), UploadName: tt.uploadName, UnpackedPath: filepath.Join(dir, "dest")}
+			if err := os.WriteFile(s.UploadPath, tt.archive(t), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.unpack(context.Background()); !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// TestSingleFileName checks the name of a compressed file that is not a tar file
+func TestSingleFileName(t *testing.T) {
+	tests := map[string]string{
+		"app.py.gz":             "app.py",
+		"dir/app.py.bz2":        "app.py",
+		`C:\Users\me\app.py.xz`: "app.py",
+		"Makefile.zst":          "Makefile",
+		"noextension":           "noextension",
+		".gz":                   "file",
+		"":                      "file",
+		"dir/":                  "dir",
+	}
+
+	for name, expected := range tests {
+		if got := singleFileName(name); got != expected {
+			t.Errorf("singleFileName(%q): expected %q, got %q", name, expected, got)
+		}
+	}
+}
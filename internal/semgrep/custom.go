@@ -0,0 +1,203 @@
+package semgrep
+
+import (
+	"bagel/internal/logger"
+	"bagel/internal/random"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRulesetExists  = errors.New("a ruleset with this name already exists")
+	ErrInvalidRules   = errors.New("invalid rules")
+	ErrRulesTooLarge  = errors.New("rules file is too large")
+	ErrEmptyRulesName = errors.New("name cannot be empty")
+)
+
+const (
+	// Maximum size of an uploaded rules file
+	MaxRulesSize = 1 << 20
+
+	// How long Semgrep may take to validate the rules
+	validateTimeout = time.Minute
+)
+
+// CustomRuleset is a rules YAML file uploaded by a user, selectable as a ruleset by its name
+type CustomRuleset struct {
+	ID         uuid.UUID `gorm:"type:text;primaryKey;"` // The UUID of the ruleset
+	Name       string    `gorm:"type:text;uniqueIndex"` // The name defined by the user, shown in the ruleset dropdown
+	Filename   string    `gorm:"type:text"`             // The name of the uploaded file
+	Rules      string    `gorm:"type:text"`             // The YAML with the rules
+	RuleCount  int       // The number of rules in the YAML
+	UploadDate time.Time // The timestamp the ruleset was uploaded
+}
+
+// rulesFile is the structure of a Semgrep rules YAML file, only the parts needed to count the rules
+type rulesFile struct {
+	Rules []struct {
+		ID string `yaml:"id"`
+	} `yaml:"rules"`
+}
+
+// AddCustomRuleset validates the rules with Semgrep and stores them under the given name
+func AddCustomRuleset(db *gorm.DB, name string, filename string, rules []byte) (custom *CustomRuleset, err error) {
+	if name == "" {
+		return nil, ErrEmptyRulesName
+	}
+
+	// Registry and local names would be ambiguous in the ruleset dropdown
+	if _, ok := Rulesets[name]; ok {
+		return nil, ErrRulesetExists
+	}
+	if _, _, err := findLocalRuleset(name); err == nil {
+		return nil, ErrRulesetExists
+	}
+
+	var count int64
+	if err := db.Model(&CustomRuleset{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrRulesetExists
+	}
+
+	ruleCount, err := ValidateRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	custom = &CustomRuleset{
+		ID:         random.UUID(),
+		Name:       name,
+		Filename:   filename,
+		Rules:      string(rules),
+		RuleCount:  ruleCount,
+		UploadDate: time.Now(),
+	}
+	if err := db.Create(custom).Error; err != nil {
+		return nil, err
+	}
+	logger.Info("Added custom ruleset '%s' with %d rules", name, ruleCount)
+
+	return custom, nil
+}
+
+// ValidateRules checks the rules YAML with 'semgrep --validate' and returns the number of rules
+func ValidateRules(rules []byte) (count int, err error) {
+	if len(rules) > MaxRulesSize {
+		return 0, ErrRulesTooLarge
+	}
+
+	return validateRules(rules)
+}
+
+// validateRules checks the rules YAML with 'semgrep --validate' without limiting its size
+func validateRules(rules []byte) (count int, err error) {
+	// Check the structure first to give a useful error for files that are not rules at all
+	ruleIDs, err := parseRuleIDs(rules)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.CreateTemp("", "bagel-rules-*.yaml")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(rules); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	// #nosec G204, the path is generated by os.CreateTemp
+	cmdValidate := exec.CommandContext(ctx, "semgrep", "scan", "--validate", "--metrics", "off", "--config", file.Name())
+	setProcessGroup(cmdValidate)
+	cmdValidate.WaitDelay = waitDelay
+
+	var output bytes.Buffer
+	cmdValidate.Stdout = &output
+	cmdValidate.Stderr = &output
+	if err := cmdValidate.Run(); err != nil {
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("validating rules: %w", ctx.Err())
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			msg := strings.TrimSpace(strings.ReplaceAll(output.String(), file.Name(), "rules"))
+			return 0, fmt.Errorf("%w: %s", ErrInvalidRules, msg)
+		}
+		return 0, err
+	}
+
+	return len(ruleIDs), nil
+}
+
+// parseRuleIDs returns the IDs of the rules in a rules YAML file
+func parseRuleIDs(rules []byte) (ruleIDs []string, err error) {
+	parsed := rulesFile{}
+	if err := yaml.Unmarshal(rules, &parsed); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRules, err)
+	}
+	if len(parsed.Rules) == 0 {
+		return nil, fmt.Errorf("%w: no rules found", ErrInvalidRules)
+	}
+
+	for i, rule := range parsed.Rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("%w: rule %d has no id", ErrInvalidRules, i+1)
+		}
+		ruleIDs = append(ruleIDs, rule.ID)
+	}
+
+	return ruleIDs, nil
+}
+
+// ListCustomRulesets returns all custom rulesets ordered by name
+func ListCustomRulesets(db *gorm.DB) (rulesets []CustomRuleset, err error) {
+	err = db.Order("name").Find(&rulesets).Error
+	return rulesets, err
+}
+
+// DeleteCustomRuleset removes a custom ruleset, queued scans using it will fail
+func DeleteCustomRuleset(db *gorm.DB, id uuid.UUID) (err error) {
+	result := db.Delete(&CustomRuleset{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRulesetNotFound
+	}
+
+	logger.Info("Deleted custom ruleset %s", id.String())
+	return nil
+}
+
+// findCustomRuleset returns the first custom ruleset matching the condition
+func findCustomRuleset(db *gorm.DB, query string, args ...interface{}) (custom *CustomRuleset, err error) {
+	custom = &CustomRuleset{}
+	result := db.Where(query, args...).Limit(1).Find(custom)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRulesetNotFound
+	}
+
+	return custom, nil
+}
@@ -0,0 +1,170 @@
+package semgrep
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrScanNotDone = errors.New("scan is not done")
+)
+
+// ScanDiff is the comparison of the findings of two scans
+type ScanDiff struct {
+	Base       *Scan     // The earlier scan
+	Head       *Scan     // The later scan
+	New        []Finding // Findings of the head scan that are not in the base scan
+	Resolved   []Finding // Findings of the base scan that are not in the head scan anymore
+	Persisting []Finding // Findings of the head scan that were already in the base scan
+}
+
+// diffKey returns the key findings are matched by in a pass of DiffScans
+type diffKey func(f *Finding) string
+
+// diffPass matches findings with the same key. Unique passes only match a key if a single finding is left with it
+// in each scan, as any pairing of several findings would be a guess
+type diffPass struct {
+	key    diffKey
+	unique bool
+}
+
+var (
+	// The passes to match findings, from the most to the least precise. The fingerprint of a finding stays the same
+	// when lines are added above, the matched code survives a different fingerprint if Semgrep only computed one in
+	// one of the scans, and the rule and path still match a finding whose code was changed if it is the only one
+	diffPasses = []diffPass{
+		{key: func(f *Finding) string { return f.Fingerprint }},
+		{key: func(f *Finding) string {
+			return f.RuleID + "\x00" + f.Path + "\x00" + strings.Join(strings.Fields(f.Lines), " ")
+		}},
+		{key: func(f *Finding) string { return f.RuleID + "\x00" + f.Path }, unique: true},
+	}
+)
+
+// DiffScans compares the findings of two scans that are done and classifies them as new, resolved or persisting
+func DiffScans(db *gorm.DB, baseID uuid.UUID, headID uuid.UUID) (diff *ScanDiff, err error) {
+	diff = &ScanDiff{}
+	if diff.Base, err = loadDoneScan(db, baseID); err != nil {
+		return nil, err
+	}
+	if diff.Head, err = loadDoneScan(db, headID); err != nil {
+		return nil, err
+	}
+
+	diff.match()
+	return diff, nil
+}
+
+// match classifies the findings of the head and base scans as new, resolved or persisting
+func (diff *ScanDiff) match() {
+	// Findings are ordered by their location, so identical findings are matched in order
+	base := diff.Base.Findings
+	head := diff.Head.Findings
+	matchedBase := make([]bool, len(base))
+	matchedHead := make([]bool, len(head))
+
+	for _, pass := range diffPasses {
+		unmatched := map[string][]int{}
+		for i := range base {
+			if !matchedBase[i] {
+				k := pass.key(&base[i])
+				unmatched[k] = append(unmatched[k], i)
+			}
+		}
+
+		unmatchedHead := map[string]int{}
+		for i := range head {
+			if !matchedHead[i] {
+				unmatchedHead[pass.key(&head[i])]++
+			}
+		}
+
+		for i := range head {
+			if matchedHead[i] {
+				continue
+			}
+
+			k := pass.key(&head[i])
+			candidates := unmatched[k]
+			if len(candidates) == 0 || pass.unique && (len(candidates) > 1 || unmatchedHead[k] > 1) {
+				continue
+			}
+			matchedBase[candidates[0]] = true
+			matchedHead[i] = true
+			unmatched[k] = candidates[1:]
+		}
+	}
+
+	for i := range head {
+		if matchedHead[i] {
+			diff.Persisting = append(diff.Persisting, head[i])
+		} else {
+			diff.New = append(diff.New, head[i])
+		}
+	}
+	for i := range base {
+		if !matchedBase[i] {
+			diff.Resolved = append(diff.Resolved, base[i])
+		}
+	}
+}
+
+// loadDoneScan loads a scan that is done together with its findings
+func loadDoneScan(db *gorm.DB, id uuid.UUID) (scan *Scan, err error) {
+	scan = &Scan{}
+	result := db.Omit("semgrep_output").Limit(1).Find(scan, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrScanNotFound
+	}
+	if scan.Status != StatusDone {
+		return nil, ErrScanNotDone
+	}
+
+	if err := scan.LoadFindings(db); err != nil {
+		return nil, err
+	}
+
+	return scan, nil
+}
+
+// PreviousScan returns the latest scan of the same codebase that is done and was uploaded before this scan
+func (s *Scan) PreviousScan(db *gorm.DB) (previous *Scan, err error) {
+	scans, err := s.EarlierScans(db, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(scans) == 0 {
+		return nil, ErrScanNotFound
+	}
+
+	return &scans[0], nil
+}
+
+// EarlierScans returns up to limit scans of the same codebase that are done and were uploaded before this scan,
+// newest first
+func (s *Scan) EarlierScans(db *gorm.DB, limit int) (scans []Scan, err error) {
+	err = s.sameCodebase(db.Model(&Scan{})).
+		Omit("semgrep_output").
+		Where("scans.id != ? AND scans.status = ? AND scans.upload_date < ?", s.ID, StatusDone, s.UploadDate).
+		Order("scans.upload_date desc").
+		Limit(limit).
+		Find(&scans).Error
+
+	return scans, err
+}
+
+// sameCodebase limits a query on or joining the scans table to the scans of the same codebase, recognized by the
+// project or, for scans without a project, by the upload name or Git URL
+func (s *Scan) sameCodebase(query *gorm.DB) *gorm.DB {
+	if s.ProjectID != uuid.Nil {
+		return query.Where("scans.project_id = ?", s.ProjectID)
+	}
+
+	return query.Where("scans.upload_name = ?", s.UploadName)
+}
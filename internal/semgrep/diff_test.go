@@ -0,0 +1,93 @@
+package semgrep
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestDiffMatch checks which passes match the findings of two scans
+func TestDiffMatch(t *testing.T) {
+	eval := func(fingerprint string, line int, code string) Finding {
+		return Finding{RuleID: "python.lang.security.audit.eval", Path: "app.py", StartLine: line, Lines: code, Fingerprint: fingerprint}
+	}
+
+	tests := []struct {
+		name       string
+		base       []Finding
+		head       []Finding
+		new        []string // The fingerprints of the new findings
+		resolved   []string
+		persisting []string
+	}{
+		{
+			name:       "same fingerprint on another line",
+			base:       []Finding{eval("a", 10, "eval(a)")},
+			head:       []Finding{eval("a", 12, "eval(a)")},
+			persisting: []string{"a"},
+		},
+		{
+			name:       "same code with another fingerprint",
+			base:       []Finding{eval("a", 10, "eval(a)")},
+			head:       []Finding{eval("a2", 10, "eval(  a )")},
+			persisting: []string{"a2"},
+		},
+		{
+			name:       "changed code of the only finding of the rule in the file",
+			base:       []Finding{eval("a", 10, "eval(a)")},
+			head:       []Finding{eval("a2", 10, "eval(a + b)")},
+			persisting: []string{"a2"},
+		},
+		{
+			// Either of the findings could have been fixed, so the changed one is not paired with a guess
+			name:     "two findings of the same rule in the same file, one fixed and one changed",
+			base:     []Finding{eval("a", 10, "eval(a)"), eval("b", 20, "eval(b)")},
+			head:     []Finding{eval("b2", 10, "eval(b + c)")},
+			new:      []string{"b2"},
+			resolved: []string{"a", "b"},
+		},
+		{
+			name:     "two findings of the same rule in the same file, both changed",
+			base:     []Finding{eval("a", 10, "eval(a)"), eval("b", 20, "eval(b)")},
+			head:     []Finding{eval("a2", 10, "eval(a + c)"), eval("b2", 20, "eval(b + c)")},
+			new:      []string{"a2", "b2"},
+			resolved: []string{"a", "b"},
+		},
+		{
+			name:       "two findings of the same rule in the same file, one unchanged and one changed",
+			base:       []Finding{eval("a", 10, "eval(a)"), eval("b", 20, "eval(b)")},
+			head:       []Finding{eval("a", 10, "eval(a)"), eval("b2", 20, "eval(b + c)")},
+			persisting: []string{"a", "b2"},
+		},
+		{
+			name:       "new finding in another file",
+			base:       []Finding{eval("a", 10, "eval(a)")},
+			head:       []Finding{eval("a", 10, "eval(a)"), {RuleID: "python.lang.security.audit.eval", Path: "other.py", Lines: "eval(a)", Fingerprint: "c"}},
+			new:        []string{"c"},
+			persisting: []string{"a"},
+		},
+	}
+
+	fingerprints := func(findings []Finding) (fingerprints []string) {
+		for _, f := range findings {
+			fingerprints = append(fingerprints, f.Fingerprint)
+		}
+		return fingerprints
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := &ScanDiff{Base: &Scan{Findings: tt.base}, Head: &Scan{Findings: tt.head}}
+			diff.match()
+
+			if got := fingerprints(diff.New); !slices.Equal(got, tt.new) {
+				t.Errorf("expected new %v, got %v", tt.new, got)
+			}
+			if got := fingerprints(diff.Resolved); !slices.Equal(got, tt.resolved) {
+				t.Errorf("expected resolved %v, got %v", tt.resolved, got)
+			}
+			if got := fingerprints(diff.Persisting); !slices.Equal(got, tt.persisting) {
+				t.Errorf("expected persisting %v, got %v", tt.persisting, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package semgrep
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The stage of a running scan
+const (
+	StageUnpacking = "unpacking" // Unpacking the upload or cloning the repository
+	StageScanning  = "scanning"  // Semgrep is running
+	StageParsing   = "parsing"   // Reading the results of Semgrep
+)
+
+const (
+	// Events a subscriber has not received yet, further events are dropped for that subscriber
+	eventBufferSize = 64
+)
+
+var (
+	// All stages a running scan goes through, in order
+	Stages = []string{StageUnpacking, StageScanning, StageParsing}
+
+	// The channels of the subscribers to scan events, with the scan they are interested in or uuid.Nil for all scans
+	subscribers   = map[chan ScanEvent]uuid.UUID{}
+	subscribersMu sync.Mutex
+)
+
+// ScanEvent is a status transition of a scan
+type ScanEvent struct {
+	ScanID uuid.UUID `json:"scan_id"`
+	State  string    `json:"state"`           // The stage of a running scan, otherwise the status
+	Status string    `json:"status"`          // The status of the scan, one of the Status constants
+	Error  string    `json:"error,omitempty"` // The error of a failed scan
+	Time   time.Time `json:"time"`
+}
+
+// Finished reports if the event is the last one of its scan
+func (e *ScanEvent) Finished() bool {
+	return e.Status == StatusDone || e.Status == StatusFailed || e.Status == StatusCancelled
+}
+
+// Event returns the current state of the scan as an event
+func (s *Scan) Event() ScanEvent {
+	state := s.Status
+	if s.Status == StatusRunning && s.Stage != "" {
+		state = s.Stage
+	}
+
+	return ScanEvent{ScanID: s.ID, State: state, Status: s.Status, Error: s.Error, Time: time.Now()}
+}
+
+// SubscribeEvents returns a channel receiving the events of the scan with the given ID, or of all scans for uuid.Nil.
+// unsubscribe has to be called once the events are not needed anymore
+func SubscribeEvents(scanID uuid.UUID) (events <-chan ScanEvent, unsubscribe func()) {
+	ch := make(chan ScanEvent, eventBufferSize)
+
+	subscribersMu.Lock()
+	subscribers[ch] = scanID
+	subscribersMu.Unlock()
+
+	return ch, func() {
+		subscribersMu.Lock()
+		delete(subscribers, ch)
+		subscribersMu.Unlock()
+	}
+}
+
+// publish sends the current state of the scan to its subscribers without blocking on slow subscribers
+func (s *Scan) publish() {
+	event := s.Event()
+
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	for ch, scanID := range subscribers {
+		if scanID != uuid.Nil && scanID != s.ID {
+			continue
+		}
+
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// setStage stores the stage of the running scan and publishes it
+func (s *Scan) setStage(db *gorm.DB, stage string) (err error) {
+	s.Stage = stage
+	if err := db.Model(&Scan{}).Where("id = ? AND status = ?", s.ID, StatusRunning).Update("stage", stage).Error; err != nil {
+		return err
+	}
+
+	s.publish()
+	return nil
+}
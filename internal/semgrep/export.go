@@ -0,0 +1,69 @@
+package semgrep
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ExportColumns are the header of the spreadsheet exports, one row per finding follows with the values of ExportRow
+	ExportColumns = []string{"Rule ID", "Vulnerability class", "CWE", "OWASP", "Severity", "Confidence", "Path", "Start line", "End line", "Code", "Message"}
+
+	// Spreadsheet applications evaluate CSV cells starting with these characters as formulas
+	formulaPrefixes = "=+-@\t\r"
+)
+
+// ExportRow returns the values of the finding in the order of ExportColumns, lists are joined by newlines
+func (f *Finding) ExportRow() []string {
+	return []string{
+		f.RuleID,
+		strings.Join(f.Meta.VulnerabilityClass.Value, "\n"),
+		strings.Join(f.Meta.Cwe.Value, "\n"),
+		strings.Join(f.Meta.Owasp.Value, "\n"),
+		f.Severity,
+		f.Meta.Confidence,
+		f.Path,
+		strconv.Itoa(f.StartLine),
+		strconv.Itoa(f.EndLine),
+		f.Lines,
+		f.Message,
+	}
+}
+
+// WriteCSV writes the findings of the scan as CSV with a header row. The findings have to be loaded first
+func (s *Scan) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ExportColumns); err != nil {
+		return err
+	}
+
+	for i := range s.Findings {
+		row := s.Findings[i].ExportRow()
+
+		// Matched code and messages come from the scanned codebase, so they must not become formulas
+		for j, value := range row {
+			if value != "" && strings.ContainsRune(formulaPrefixes, rune(value[0])) {
+				row[j] = "'" + value
+			}
+		}
+
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteXLSX writes the findings of the scan as an Excel workbook with a header row. The findings have to be loaded first
+func (s *Scan) WriteXLSX(w io.Writer) error {
+	rows := make([][]string, 0, len(s.Findings))
+	for i := range s.Findings {
+		rows = append(rows, s.Findings[i].ExportRow())
+	}
+
+	return writeXLSX(w, "Findings", ExportColumns, rows)
+}
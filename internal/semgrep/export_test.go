@@ -0,0 +1,44 @@
+package semgrep
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+)
+
+// TestWriteCSV checks the columns of the export and that values are not evaluated as formulas
+func TestWriteCSV(t *testing.T) {
+	f := Finding{
+		RuleID: "python.lang.security.audit.eval", Path: "app/main.py", StartLine: 3, EndLine: 5,
+		Severity: "ERROR", Message: "=HYPERLINK(\"https://example.com\")", Lines: "eval(\n  data\n)",
+	}
+	f.Meta.Confidence = "HIGH"
+	f.Meta.VulnerabilityClass.Value = []string{"Code Injection"}
+	f.Meta.Cwe.Value = []string{"CWE-95", "CWE-94"}
+	f.Meta.Owasp.Value = []string{"A03:2021 - Injection"}
+	s := Scan{Findings: []Finding{f}}
+
+	var b bytes.Buffer
+	if err := s.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"Rule ID", "Vulnerability class", "CWE", "OWASP", "Severity", "Confidence", "Path", "Start line", "End line", "Code", "Message"},
+		{"python.lang.security.audit.eval", "Code Injection", "CWE-95\nCWE-94", "A03:2021 - Injection", "ERROR", "HIGH", "app/main.py", "3", "5", "eval(\n  data\n)", "'=HYPERLINK(\"https://example.com\")"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i := range expected {
+		if !slices.Equal(records[i], expected[i]) {
+			t.Errorf("record %d: expected %q, got %q", i, expected[i], records[i])
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package semgrep
+
+import (
+	"bagel/internal/random"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// Semgrep does not compute fingerprints without logging in
+	fingerprintRequiresLogin = "requires login"
+
+	// Number of findings inserted with a single statement
+	findingsBatchSize = 500
+)
+
+// Finding is a single Semgrep result of a scan
+type Finding struct {
+	ID          uuid.UUID  `gorm:"type:text;primaryKey;"` // The UUID of the finding
+	ScanID      uuid.UUID  `gorm:"type:text;index"`       // The scan the finding belongs to
+	RuleID      string     `gorm:"type:text;index"`       // The check_id of the Semgrep rule
+	Path        string     `gorm:"type:text;index"`       // The path relative to the scanned directory
+	StartLine   int        // The first line of the match
+	StartCol    int        // The first column of the match
+	EndLine     int        // The last line of the match
+	EndCol      int        // The column after the match
+	Severity    string     `gorm:"type:text;index"` // The severity of the rule, like ERROR or WARNING
+	Message     string     `gorm:"type:text"`       // The message of the rule
+	Lines       string     `gorm:"type:text"`       // The matched source code
+	Metadata    string     `gorm:"type:text"`       // The metadata of the rule as JSON
+	Fingerprint string     `gorm:"type:text;index"` // Identifies the same finding across scans
+	Ignored     bool       // If the finding was ignored with a nosemgrep comment
+	Rulesets    StringList `gorm:"type:text"` // The rulesets of the scan the rule came from
+
+	TriageStatus  string    `gorm:"type:text;index;default:untriaged"` // The decision of the reviewer, one of TriageStatuses
+	TriageComment string    `gorm:"type:text"`                         // Why the reviewer made the decision
+	TriagedBy     string    `gorm:"type:text"`                         // The username of the reviewer
+	TriagedAt     time.Time // When the decision was made, zero if the finding was never triaged
+	TriageScanID  uuid.UUID `gorm:"type:text"` // The scan the decision was made in, an earlier scan if it was carried forward
+
+	Meta Metadata `gorm:"-"` // The parsed metadata, set when loading from the database
+}
+
+// StringList is a list of strings stored as JSON, as sqlite does not have support for arrays
+type StringList []string
+
+// AfterFind parses the metadata after loading a finding from the database
+func (f *Finding) AfterFind(tx *gorm.DB) (err error) {
+	if f.Metadata == "" {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(f.Metadata), &f.Meta); err != nil {
+		return fmt.Errorf("error unmarshalling metadata of finding %s: %s", f.ID.String(), err)
+	}
+
+	return nil
+}
+
+// LoadFindings loads the findings of the scan ordered by their location
+func (s *Scan) LoadFindings(db *gorm.DB) (err error) {
+	return db.Where("scan_id = ?", s.ID).Order("path, start_line, start_col, rule_id").Find(&s.Findings).Error
+}
+
+// buildFindings converts the Semgrep output into findings, attributed to rulesets with the rule sources
+func (s *Scan) buildFindings() (err error) {
+	output := semgrepResults{}
+	if err := json.Unmarshal([]byte(s.SemgrepOutput), &output); err != nil {
+		return fmt.Errorf("error unmarshalling JSON for scan %s: %s", s.ID.String(), err)
+	}
+
+	// Scans from before multiple rulesets have no sources, all results came from the only ruleset
+	sources := map[string][]string{}
+	if s.RuleSources != "" {
+		if err := json.Unmarshal([]byte(s.RuleSources), &sources); err != nil {
+			return fmt.Errorf("error unmarshalling rule sources for scan %s: %s", s.ID.String(), err)
+		}
+	}
+
+	s.Findings = make([]Finding, 0, len(output.Results))
+	occurrences := map[string]int{}
+	for _, result := range output.Results {
+		rulesets, ok := sources[result.CheckID]
+		if !ok {
+			rulesets = s.Rulesets.Names()
+		}
+
+		metadata := string(result.Extra.Metadata)
+		if metadata == "" || metadata == "null" {
+			metadata = "{}"
+		}
+
+		f := Finding{
+			ID:           random.UUID(),
+			ScanID:       s.ID,
+			RuleID:       result.CheckID,
+			Path:         result.Path,
+			StartLine:    result.Start.Line,
+			StartCol:     result.Start.Col,
+			EndLine:      result.End.Line,
+			EndCol:       result.End.Col,
+			Severity:     result.Extra.Severity,
+			Message:      result.Extra.Message,
+			Lines:        result.Extra.Lines,
+			Metadata:     metadata,
+			Ignored:      result.Extra.IsIgnored,
+			Rulesets:     rulesets,
+			TriageStatus: TriageUntriaged,
+		}
+		f.Fingerprint = fingerprint(&result, occurrences)
+		if err := f.AfterFind(nil); err != nil {
+			return err
+		}
+
+		s.Findings = append(s.Findings, f)
+	}
+
+	return nil
+}
+
+// fingerprint returns the fingerprint Semgrep computed for the result. Without a login Semgrep does not compute them,
+// so one is derived from the rule, the path and the matched code, which stays the same when lines are added above.
+// Identical matches in the same file are told apart by counting them in occurrences
+func fingerprint(result *Result, occurrences map[string]int) string {
+	if result.Extra.Fingerprint != "" && result.Extra.Fingerprint != fingerprintRequiresLogin {
+		return result.Extra.Fingerprint
+	}
+	if result.Extra.MatchBasedID != "" {
+		return result.Extra.MatchBasedID
+	}
+
+	code := strings.Join(strings.Fields(result.Extra.Lines), " ")
+	key := result.CheckID + "\x00" + result.Path + "\x00" + code
+	occurrences[key]++
+
+	hash := sha256.Sum256([]byte(key + "\x00" + strconv.Itoa(occurrences[key])))
+	return hex.EncodeToString(hash[:])
+}
+
+// BackfillFindings creates the findings of scans that finished before findings were stored in their own table
+func BackfillFindings(db *gorm.DB) (err error) {
+	var scans []Scan
+	return db.Where("status = ?", StatusDone).FindInBatches(&scans, 10, func(tx *gorm.DB, batch int) error {
+		for i := range scans {
+			scan := &scans[i]
+			if err := scan.buildFindings(); err != nil {
+				// Do not fail the startup for a single broken scan
+				scan.log().ErrorF("error creating findings: %s", err)
+				continue
+			}
+
+			if len(scan.Findings) > 0 {
+				if err := db.CreateInBatches(scan.Findings, findingsBatchSize).Error; err != nil {
+					return err
+				}
+			}
+			scan.log().Info("Created %d findings", len(scan.Findings))
+		}
+
+		return nil
+	}).Error
+}
+
+// DeleteScan removes a scan and its findings from the database
+func DeleteScan(db *gorm.DB, id uuid.UUID) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Finding{}, "scan_id = ?", id).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&Scan{}, "id = ?", id).Error
+	})
+}
+
+// Value stores the list as JSON
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Scan reads the list from JSON
+func (l *StringList) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported type %T for a string list", value)
+	}
+
+	if len(b) == 0 {
+		*l = nil
+		return nil
+	}
+
+	return json.Unmarshal(b, l)
+}
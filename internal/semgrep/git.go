@@ -0,0 +1,153 @@
+package semgrep
+
+import (
+	"bagel/internal/config"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// List of allowed URL schemes for Git repositories, file:// is only allowed with BAGEL_GIT_ALLOW_FILE=true
+	allowedGitSchemes = []string{"https", "http", "ssh", "git"}
+
+	// Matches SCP-like Git URLs such as git@github.com:rtfmkiesel/bagel.git
+	regexGitSCP = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[A-Za-z0-9._~/-]+$`)
+
+	// Matches branch names, tag names and commit SHAs
+	regexGitRef = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+	// Matches (abbreviated) commit SHAs
+	regexGitSHA = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+)
+
+// ValidateGitURL checks if the given URL is a Git URL that can be cloned without credentials in it
+func ValidateGitURL(gitURL string) error {
+	if strings.HasPrefix(gitURL, "-") {
+		return fmt.Errorf("invalid Git URL: %s", gitURL)
+	}
+
+	if regexGitSCP.MatchString(gitURL) {
+		return nil
+	}
+
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return fmt.Errorf("invalid Git URL: %s", gitURL)
+	}
+
+	schemes := allowedGitSchemes
+	if config.Bool("BAGEL_GIT_ALLOW_FILE", false) {
+		schemes = append(slices.Clone(schemes), "file")
+	}
+
+	if !slices.Contains(schemes, u.Scheme) {
+		return fmt.Errorf("Git URL scheme must be one of '%s': %s", schemes, gitURL)
+	}
+
+	if u.Scheme != "file" && u.Host == "" {
+		return fmt.Errorf("Git URL is missing a host: %s", gitURL)
+	}
+
+	// The URL is logged, stored and shown, so it must not carry a token or password.
+	// Only SSH URLs may name the user, as they authenticate with a key
+	if _, hasPassword := u.User.Password(); hasPassword || (u.User != nil && u.Scheme != "ssh") {
+		return fmt.Errorf("Git URL cannot contain credentials: %s", u.Host)
+	}
+
+	return nil
+}
+
+// ValidateGitRef checks if the given ref is a valid branch, tag or commit SHA
+func ValidateGitRef(ref string) error {
+	if ref == "" {
+		// Empty means the default branch
+		return nil
+	}
+
+	if strings.HasPrefix(ref, "-") || strings.Contains(ref, "..") || !regexGitRef.MatchString(ref) {
+		return fmt.Errorf("invalid Git ref: %s", ref)
+	}
+
+	return nil
+}
+
+// clone clones the Git repository of the scan into the unpacked directory and records the resolved commit
+func (s *Scan) clone(ctx context.Context) (err error) {
+	s.log().Info("Cloning %s", s.GitURL)
+
+	// A commit SHA can not be passed to --branch, so those need a full clone
+	args := []string{"clone", "--quiet", "--no-checkout"}
+	if !regexGitSHA.MatchString(s.GitRef) {
+		args = append(args, "--depth", "1")
+		if s.GitRef != "" {
+			args = append(args, "--branch", s.GitRef)
+		}
+	}
+	args = append(args, "--", s.GitURL, s.UnpackedPath)
+
+	if _, err := runGit(ctx, args...); err != nil {
+		return fmt.Errorf("error cloning %s: %s", s.GitURL, err)
+	}
+
+	commit, err := s.resolveRef(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := runGit(ctx, "-C", s.UnpackedPath, "checkout", "--quiet", "--detach", commit); err != nil {
+		return fmt.Errorf("error checking out %s: %s", commit, err)
+	}
+
+	s.GitCommit = commit
+	s.log().Info("Checked out %s at %s", s.GitURL, s.GitCommit)
+
+	return nil
+}
+
+// resolveRef resolves the ref of the scan to a full commit SHA inside the cloned repository
+func (s *Scan) resolveRef(ctx context.Context) (commit string, err error) {
+	candidates := []string{"HEAD"}
+	if s.GitRef != "" {
+		// Branches only exist as remote branches after cloning
+		candidates = []string{"refs/remotes/origin/" + s.GitRef, "refs/tags/" + s.GitRef, s.GitRef}
+	}
+
+	for _, candidate := range candidates {
+		out, err := runGit(ctx, "-C", s.UnpackedPath, "rev-parse", "--verify", "--quiet", candidate+"^{commit}")
+		if err == nil {
+			return out, nil
+		}
+	}
+
+	if s.GitRef == "" {
+		return "", fmt.Errorf("repository %s has no commits", s.GitURL)
+	}
+
+	return "", fmt.Errorf("ref %s not found in %s", s.GitRef, s.GitURL)
+}
+
+// runGit runs git with the given arguments and returns the trimmed output.
+// Prompts are disabled so that a clone requiring credentials fails instead of hanging
+func runGit(ctx context.Context, args ...string) (out string, err error) {
+	cmdGit := exec.CommandContext(ctx, "git", args...) // #nosec G204, URL and ref are validated before being added to the queue
+	cmdGit.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	setProcessGroup(cmdGit)
+	cmdGit.WaitDelay = waitDelay
+
+	output, err := cmdGit.CombinedOutput()
+	out = strings.TrimSpace(string(output))
+	if err != nil {
+		if out != "" {
+			return "", fmt.Errorf("%s", out)
+		}
+		return "", err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,289 @@
+package semgrep
+
+import (
+	"bagel/internal/config"
+	"bagel/internal/logger"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Directory with the local rulesets, one YAML file per ruleset named after the file
+	rulesDir = config.String("BAGEL_RULES_DIR", "rules")
+
+	// Do not offer the registry rulesets, for scanners without network access
+	rulesOffline = config.Bool("BAGEL_RULES_OFFLINE", false)
+
+	// Where registry rulesets are downloaded from when creating a snapshot, the ruleset name is appended
+	registryURL = config.String("BAGEL_REGISTRY_URL", "https://semgrep.dev/c/p/")
+
+	// How long downloading a registry ruleset may take
+	snapshotTimeout = config.Duration("BAGEL_SNAPSHOT_TIMEOUT", 2*time.Minute)
+
+	// Rule IDs of the local rulesets, only parsed again once a file changed
+	localCache   = map[string]localCacheEntry{}
+	localCacheMu sync.Mutex
+
+	// Names of local rulesets and registry rulesets that can be snapshotted
+	validRulesetName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+	ErrInvalidRulesetName = errors.New("invalid ruleset name")
+	ErrNotSnapshot        = errors.New("a local ruleset that is not a snapshot exists with this name")
+)
+
+const (
+	// Maximum size of a downloaded registry ruleset
+	maxSnapshotSize = 64 << 20
+
+	// The first line of every snapshot, only files starting with it are replaced by a new snapshot
+	snapshotHeader = "# Snapshot of a Semgrep registry ruleset created by Bagel, it is replaced by the next snapshot\n"
+)
+
+// LocalRuleset is a YAML file in the rules directory, selectable as a ruleset without network access
+type LocalRuleset struct {
+	Name      string    // The name of the file without the extension
+	Path      string    // The path to the file
+	RuleCount int       // The number of rules in the file
+	UpdatedAt time.Time // The modification time of the file
+}
+
+type localCacheEntry struct {
+	modTime time.Time
+	size    int64
+	ruleIDs []string
+	err     error // Invalid files are only reported once until they change
+}
+
+// ListLocalRulesets returns the rulesets in the rules directory ordered by name.
+// Files that are not valid rules are skipped, the warning is logged when they are parsed
+func ListLocalRulesets() (rulesets []LocalRuleset, err error) {
+	entries, err := os.ReadDir(rulesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name, ok := localRulesetName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+
+		local, err := loadLocalRuleset(name, filepath.Join(rulesDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		rulesets = append(rulesets, local)
+	}
+
+	sort.Slice(rulesets, func(i, j int) bool { return rulesets[i].Name < rulesets[j].Name })
+	return rulesets, nil
+}
+
+// findLocalRuleset returns the local ruleset with the given name
+func findLocalRuleset(name string) (local LocalRuleset, ruleIDs []string, err error) {
+	if !validRulesetName.MatchString(name) {
+		return LocalRuleset{}, nil, ErrRulesetNotFound
+	}
+
+	for _, ext := range []string{".yaml", ".yml"} {
+		path := filepath.Join(rulesDir, name+ext)
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		local, err = loadLocalRuleset(name, path)
+		if err != nil {
+			return LocalRuleset{}, nil, err
+		}
+
+		ruleIDs, err = localRuleIDs(path)
+		return local, ruleIDs, err
+	}
+
+	return LocalRuleset{}, nil, ErrRulesetNotFound
+}
+
+// loadLocalRuleset reads the metadata and counts the rules of a local ruleset
+func loadLocalRuleset(name string, path string) (local LocalRuleset, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return LocalRuleset{}, err
+	}
+
+	ruleIDs, err := localRuleIDs(path)
+	if err != nil {
+		return LocalRuleset{}, err
+	}
+
+	return LocalRuleset{Name: name, Path: path, RuleCount: len(ruleIDs), UpdatedAt: info.ModTime()}, nil
+}
+
+// localRuleIDs returns the rule IDs of a local ruleset, cached until the file changes
+func localRuleIDs(path string) (ruleIDs []string, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	localCacheMu.Lock()
+	entry, ok := localCache[path]
+	localCacheMu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.ruleIDs, entry.err
+	}
+
+	rules, err := os.ReadFile(path) // #nosec G304, the path is inside the rules directory
+	if err != nil {
+		return nil, err
+	}
+
+	ruleIDs, err = parseRuleIDs(rules)
+	if err != nil {
+		logger.Warning("Skipping local ruleset %s: %s", path, err)
+	}
+
+	localCacheMu.Lock()
+	localCache[path] = localCacheEntry{modTime: info.ModTime(), size: info.Size(), ruleIDs: ruleIDs, err: err}
+	localCacheMu.Unlock()
+
+	return ruleIDs, err
+}
+
+// localRulesetName returns the ruleset name of a file in the rules directory
+func localRulesetName(filename string) (name string, ok bool) {
+	ext := filepath.Ext(filename)
+	if ext != ".yaml" && ext != ".yml" {
+		return "", false
+	}
+
+	name = strings.TrimSuffix(filename, ext)
+	return name, validRulesetName.MatchString(name)
+}
+
+// SnapshotRegistry downloads a registry ruleset into the rules directory, so it can be used without network
+// access. An existing snapshot is replaced, other local rulesets are never overwritten. As the local ruleset
+// has the same name, it is used instead of the registry
+func SnapshotRegistry(name string) (local LocalRuleset, err error) {
+	if _, ok := Rulesets[name]; !ok {
+		return LocalRuleset{}, fmt.Errorf("%w: %s", ErrInvalidRulesetName, name)
+	}
+
+	path := filepath.Join(rulesDir, name+".yaml")
+	if err := checkSnapshot(name); err != nil {
+		return LocalRuleset{}, err
+	}
+
+	logger.Info("Creating a snapshot of registry ruleset %s", name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, registryURL+name, nil)
+	if err != nil {
+		return LocalRuleset{}, err
+	}
+	req.Header.Set("Accept", "application/x-yaml, text/yaml, */*")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return LocalRuleset{}, fmt.Errorf("error downloading registry ruleset %s: %s", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return LocalRuleset{}, fmt.Errorf("%w: %s", ErrRulesetNotFound, name)
+		}
+		return LocalRuleset{}, fmt.Errorf("error downloading registry ruleset %s: %s", name, resp.Status)
+	}
+
+	rules, err := io.ReadAll(io.LimitReader(resp.Body, maxSnapshotSize+1))
+	if err != nil {
+		return LocalRuleset{}, err
+	}
+	if len(rules) > maxSnapshotSize {
+		return LocalRuleset{}, ErrRulesTooLarge
+	}
+
+	// The same validation as for uploaded rules, a local ruleset is used by every scan selecting it
+	if _, err := validateRules(rules); err != nil {
+		return LocalRuleset{}, err
+	}
+
+	if err := os.MkdirAll(rulesDir, 0o750); err != nil {
+		return LocalRuleset{}, err
+	}
+
+	// Write to a temporary file first so scans never see a partial ruleset
+	file, err := os.CreateTemp(rulesDir, ".snapshot-*")
+	if err != nil {
+		return LocalRuleset{}, err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(snapshotHeader); err != nil {
+		file.Close()
+		return LocalRuleset{}, err
+	}
+	if _, err := file.Write(rules); err != nil {
+		file.Close()
+		return LocalRuleset{}, err
+	}
+	if err := file.Close(); err != nil {
+		return LocalRuleset{}, err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return LocalRuleset{}, err
+	}
+
+	local, err = loadLocalRuleset(name, path)
+	if err != nil {
+		return LocalRuleset{}, err
+	}
+	logger.Info("Saved snapshot of registry ruleset %s with %d rules to %s", name, local.RuleCount, path)
+
+	return local, nil
+}
+
+// checkSnapshot fails if a local ruleset with the name exists that is not a snapshot, so it is not replaced
+func checkSnapshot(name string) (err error) {
+	for _, ext := range []string{".yaml", ".yml"} {
+		path := filepath.Join(rulesDir, name+ext)
+
+		f, err := os.Open(path) // #nosec G304, the name is one of the registry rulesets
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		head := make([]byte, len(snapshotHeader))
+		_, err = io.ReadFull(f, head)
+		f.Close()
+		if ext != ".yaml" || err != nil || string(head) != snapshotHeader {
+			return fmt.Errorf("%w: %s", ErrNotSnapshot, filepath.Base(path))
+		}
+	}
+
+	return nil
+}
+
+// RulesOffline reports if the registry rulesets are disabled
+func RulesOffline() bool {
+	return rulesOffline
+}
@@ -0,0 +1,64 @@
+package semgrep
+
+import (
+	"bagel/internal/logger"
+	"bagel/internal/metrics"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	metricWorkers = metrics.NewGauge("bagel_workers", "Number of workers by state, busy or idle", "state")
+
+	metricScansFinished = metrics.NewCounter("bagel_scans_finished_total", "Number of finished scans by status, done, failed or cancelled", "status")
+
+	metricScanDuration = metrics.NewHistogram("bagel_scan_duration_seconds", "Duration of scans run by a worker by ruleset and status, from being claimed until finished",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}, "ruleset", "status")
+
+	metricUnpackDuration = metrics.NewHistogram("bagel_unpack_duration_seconds", "Duration of unpacking an archive or cloning a repository by source, archive or git",
+		[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "source")
+
+	metricScanFindings = metrics.NewHistogram("bagel_scan_findings", "Number of findings of scans that are done",
+		[]float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000})
+)
+
+// registerQueueMetrics adds the metrics read from the queue in the database
+func registerQueueMetrics(db *gorm.DB) {
+	// The queue lives in the database, chanJobs only wakes up idle workers
+	metrics.NewGaugeFunc("bagel_queue_depth", "Number of scans waiting for a worker", func() float64 {
+		var count int64
+		if err := db.Model(&Scan{}).Where("status = ?", StatusQueued).Count(&count).Error; err != nil {
+			logger.ErrorF("error counting queued scans: %s", err)
+		}
+		return float64(count)
+	})
+
+	metricWorkers.Set(float64(workerCount), "idle")
+	metricWorkers.Set(0, "busy")
+}
+
+// observeFinished records a finished scan, the duration is only known for scans run by a worker
+func (s *Scan) observeFinished() {
+	metricScansFinished.Inc(s.Status)
+
+	if !s.StartedAt.IsZero() {
+		for _, ruleset := range s.Rulesets {
+			metricScanDuration.Observe(s.FinishedAt.Sub(s.StartedAt).Seconds(), ruleset.Name, s.Status)
+		}
+	}
+
+	if s.Status == StatusDone {
+		metricScanFindings.Observe(float64(len(s.Findings)))
+	}
+}
+
+// observeUnpack records how long getting the files to scan took
+func (s *Scan) observeUnpack(start time.Time) {
+	source := "archive"
+	if s.GitURL != "" {
+		source = "git"
+	}
+
+	metricUnpackDuration.Observe(time.Since(start).Seconds(), source)
+}
@@ -0,0 +1,278 @@
+package semgrep
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The layout of the pages in points, A4 with a margin on every side
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 50.0
+	pdfTextWidth  = pdfPageWidth - 2*pdfMargin
+
+	// The height of a line relative to the font size
+	pdfLineSpacing = 1.35
+)
+
+// pdfFont is one of the standard fonts every PDF reader has, so no font has to be embedded
+type pdfFont struct {
+	resource string   // The name of the font in the resources of the pages
+	baseFont string   // The name of the standard font
+	widths   *[95]int // The widths of the printable ASCII characters in 1/1000 of the font size, nil for monospace
+}
+
+// The widths of the standard fonts from their Adobe font metrics
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+
+	pdfRegular = &pdfFont{"F1", "Helvetica", &helveticaWidths}
+	pdfBold    = &pdfFont{"F2", "Helvetica-Bold", &helveticaBoldWidths}
+	pdfMono    = &pdfFont{"F3", "Courier", nil}
+	pdfFonts   = []*pdfFont{pdfRegular, pdfBold, pdfMono}
+
+	// Characters outside of Latin-1 that exist in the WinAnsi encoding of the standard fonts
+	winAnsiCharacters = map[rune]byte{
+		'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+	}
+)
+
+// pdfColor is an RGB color with components from 0 to 1
+type pdfColor [3]float64
+
+var (
+	pdfBlack = pdfColor{0, 0, 0}
+	pdfGray  = pdfColor{0.4, 0.4, 0.4}
+)
+
+// pdfDocument lays out text from the top to the bottom of A4 pages and starts a new page when one is full
+type pdfDocument struct {
+	title string
+	pages []*bytes.Buffer // The content streams of the pages
+	page  *bytes.Buffer   // The content stream of the current page
+	y     float64         // The position of the top of the next line on the current page
+}
+
+// newPDFDocument creates a document with an empty first page
+func newPDFDocument(title string) *pdfDocument {
+	d := &pdfDocument{title: title}
+	d.newPage()
+
+	return d
+}
+
+// newPage starts a new page
+func (d *pdfDocument) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// ensure starts a new page if the current page has less than height points left
+func (d *pdfDocument) ensure(height float64) {
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+}
+
+// space adds vertical space, at most up to the end of the page
+func (d *pdfDocument) space(height float64) {
+	d.y = max(d.y-height, pdfMargin)
+}
+
+// line draws a horizontal line across the text width
+func (d *pdfDocument) line(color pdfColor) {
+	d.ensure(6)
+	d.space(3)
+	fmt.Fprintf(d.page, "%.3f %.3f %.3f RG 0.5 w %.2f %.2f m %.2f %.2f l S\n", color[0], color[1], color[2], pdfMargin, d.y, pdfMargin+pdfTextWidth, d.y)
+	d.space(3)
+}
+
+// paragraph writes text wrapped to the text width minus the indent, lines in the text are kept
+func (d *pdfDocument) paragraph(font *pdfFont, size float64, color pdfColor, indent float64, text string) {
+	height := size * pdfLineSpacing
+	for _, line := range wrapPDFText(font, size, pdfTextWidth-indent, text) {
+		d.ensure(height)
+		d.y -= height
+		d.text(font, size, color, pdfMargin+indent, d.y+(height-size)/2+size*0.2, line)
+	}
+}
+
+// code writes text in a monospace font on a gray background
+func (d *pdfDocument) code(size float64, text string) {
+	height := size * pdfLineSpacing
+	for _, line := range wrapPDFText(pdfMono, size, pdfTextWidth-8, strings.TrimRight(text, "\n")) {
+		d.ensure(height)
+		d.y -= height
+		fmt.Fprintf(d.page, "0.93 0.93 0.93 rg %.2f %.2f %.2f %.2f re f\n", pdfMargin, d.y, pdfTextWidth, height)
+		d.text(pdfMono, size, pdfBlack, pdfMargin+4, d.y+(height-size)/2+size*0.2, line)
+	}
+}
+
+// row writes a single line of cells starting at the given offsets from the margin, cells are not wrapped
+func (d *pdfDocument) row(font *pdfFont, size float64, offsets []float64, cells ...string) {
+	height := size * pdfLineSpacing
+	d.ensure(height)
+	d.y -= height
+	for i, cell := range cells {
+		d.text(font, size, pdfBlack, pdfMargin+offsets[i], d.y+(height-size)/2+size*0.2, cell)
+	}
+}
+
+// text writes a single line at the given position of the current page
+func (d *pdfDocument) text(font *pdfFont, size float64, color pdfColor, x float64, y float64, s string) {
+	fmt.Fprintf(d.page, "BT %.3f %.3f %.3f rg /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", color[0], color[1], color[2], font.resource, size, x, y, pdfString(s))
+}
+
+// write writes the document as a PDF file with a page number in the footer of every page
+func (d *pdfDocument) write(w io.Writer) error {
+	// The catalog, the page tree, the fonts and the document information come first, then a page and its content
+	// stream for every page
+	pageObject := func(i int) int { return 4 + len(pdfFonts) + 2*i }
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // The page tree is added once the page objects are known
+		fmt.Sprintf("<< /Title %s /Producer (Bagel) /CreationDate (D:%s) >>", pdfString(d.title), time.Now().UTC().Format("20060102150405Z")),
+	}
+
+	var fonts, kids strings.Builder
+	for i, font := range pdfFonts {
+		objects = append(objects, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.baseFont))
+		fmt.Fprintf(&fonts, "/%s %d 0 R ", font.resource, 4+i)
+	}
+
+	for i, page := range d.pages {
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(d.pages))
+		fmt.Fprintf(page, "BT %.3f %.3f %.3f rg /%s 8 Tf %.2f %.2f Td %s Tj ET\n", pdfGray[0], pdfGray[1], pdfGray[2], pdfRegular.resource, pdfMargin+pdfTextWidth-measurePDFText(pdfRegular, 8, footer), pdfMargin/2, pdfString(footer))
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(page.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s>> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, fonts.String(), pageObject(i)+1),
+			fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()),
+		)
+		fmt.Fprintf(&kids, "%d 0 R ", pageObject(i))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(d.pages))
+
+	// The cross-reference table lists the byte offset of every object
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// wrapPDFText splits text into lines that fit into the width, breaking at spaces where possible
+func wrapPDFText(font *pdfFont, size float64, width float64, text string) (lines []string) {
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		line := ""
+		for _, word := range strings.SplitAfter(paragraph, " ") {
+			if measurePDFText(font, size, line+word) <= width {
+				line += word
+				continue
+			}
+			if line != "" {
+				lines = append(lines, strings.TrimRight(line, " "))
+				line = ""
+			}
+
+			// Words wider than a line are broken anywhere
+			for measurePDFText(font, size, word) > width {
+				runes := []rune(word)
+				n := 1
+				for n < len(runes) && measurePDFText(font, size, string(runes[:n+1])) <= width {
+					n++
+				}
+				lines = append(lines, string(runes[:n]))
+				word = string(runes[n:])
+			}
+			line = word
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+
+	return lines
+}
+
+// measurePDFText returns the width of the text in points
+func measurePDFText(font *pdfFont, size float64, text string) float64 {
+	width := 0
+	for _, r := range text {
+		switch {
+		case font.widths == nil:
+			width += 600
+		case r >= ' ' && r <= '~':
+			width += font.widths[r-' ']
+		default:
+			width += 556
+		}
+	}
+
+	return float64(width) * size / 1000
+}
+
+// pdfString encodes text as a PDF string in the WinAnsi encoding, characters it does not contain are replaced
+func pdfString(text string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range text {
+		c, ok := winAnsiCharacters[r]
+		switch {
+		case ok:
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			c = byte(r)
+		case r >= ' ' && r <= '~' || r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		case r < ' ':
+			c = ' '
+		default:
+			c = '?'
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte(')')
+
+	return b.String()
+}
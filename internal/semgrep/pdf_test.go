@@ -0,0 +1,173 @@
+package semgrep
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	// The trailer and the position of the cross-reference table at the end of a PDF file
+	pdfTrailer = regexp.MustCompile(`trailer\n<< /Size (\d+) /Root 1 0 R /Info 3 0 R >>\nstartxref\n(\d+)\n%%EOF\n$`)
+
+	// An entry of the cross-reference table of an object in use
+	pdfXrefEntry = regexp.MustCompile(`^(\d{10}) 00000 n $`)
+
+	// A content stream with its length
+	pdfStream = regexp.MustCompile(`(?s)<< /Length (\d+) /Filter /FlateDecode >>\nstream\n(.*?)\nendstream`)
+)
+
+// checkPDF checks the cross-reference table of a PDF file and returns its decompressed content streams
+func checkPDF(t *testing.T, b []byte) (streams []string) {
+	t.Helper()
+
+	if !bytes.HasPrefix(b, []byte("%PDF-1.4\n")) {
+		t.Fatalf("expected the PDF header, got %q", b[:min(len(b), 16)])
+	}
+
+	trailer := pdfTrailer.FindSubmatch(b)
+	if trailer == nil {
+		t.Fatalf("expected a trailer at the end, got %q", b[max(0, len(b)-100):])
+	}
+	size, _ := strconv.Atoi(string(trailer[1]))
+	xref, _ := strconv.Atoi(string(trailer[2]))
+
+	// The table starts at the offset of startxref and points to every object by its byte offset
+	lines := strings.Split(string(b[xref:]), "\n")
+	if lines[0] != "xref" || lines[1] != "0 "+strconv.Itoa(size) || lines[2] != "0000000000 65535 f " {
+		t.Fatalf("expected a cross-reference table at %d, got %q", xref, lines[:3])
+	}
+	for i := 1; i < size; i++ {
+		entry := pdfXrefEntry.FindStringSubmatch(lines[2+i])
+		if entry == nil {
+			t.Fatalf("object %d: invalid cross-reference entry %q", i, lines[2+i])
+		}
+		offset, _ := strconv.Atoi(entry[1])
+		if object := strconv.Itoa(i) + " 0 obj\n"; !bytes.HasPrefix(b[offset:], []byte(object)) {
+			t.Errorf("object %d: offset %d points to %q", i, offset, b[offset:min(len(b), offset+16)])
+		}
+	}
+	if lines[2+size] != "trailer" {
+		t.Errorf("expected %d entries in the cross-reference table, got %q after them", size, lines[2+size])
+	}
+
+	for _, stream := range pdfStream.FindAllSubmatch(b, -1) {
+		if length, _ := strconv.Atoi(string(stream[1])); length != len(stream[2]) {
+			t.Errorf("expected a stream of %d bytes, got %d", length, len(stream[2]))
+		}
+
+		r, err := zlib.NewReader(bytes.NewReader(stream[2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, string(content))
+	}
+
+	return streams
+}
+
+// TestPDFDocument checks the structure of a document and the encoding of its text
+func TestPDFDocument(t *testing.T) {
+	d := newPDFDocument(`Report (draft) \ 1`)
+	d.paragraph(pdfRegular, 10, pdfBlack, 0, `eval(input()) in C:\app`)
+	d.paragraph(pdfRegular, 10, pdfBlack, 0, "Café – “quoted” €5")
+	d.code(8, "print('日本語 Ω')")
+
+	var b bytes.Buffer
+	if err := d.write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	streams := checkPDF(t, b.Bytes())
+	if len(streams) != 1 {
+		t.Fatalf("expected 1 page, got %d", len(streams))
+	}
+
+	if !bytes.Contains(b.Bytes(), []byte(`/Title (Report \(draft\) \\ 1)`)) {
+		t.Error("expected the escaped title in the document information")
+	}
+	for _, expected := range []string{
+		// Parentheses and backslashes are escaped
+		`(eval\(input\(\)\) in C:\\app) Tj`,
+		// Latin-1 and the WinAnsi characters are encoded as single bytes
+		"(Caf\xe9 \x96 \x93quoted\x94 \x805) Tj",
+		// Characters missing from the standard fonts are replaced
+		`(print\('??? ?'\)) Tj`,
+		"(Page 1 of 1) Tj",
+	} {
+		if !strings.Contains(streams[0], expected) {
+			t.Errorf("expected %q in the content stream:\n%s", expected, streams[0])
+		}
+	}
+}
+
+// TestPDFDocumentPages checks that full pages continue on a new page and every page has a footer
+func TestPDFDocumentPages(t *testing.T) {
+	d := newPDFDocument("Pages")
+	for i := 0; i < 200; i++ {
+		d.paragraph(pdfRegular, 10, pdfBlack, 0, "Line "+strconv.Itoa(i))
+	}
+
+	var b bytes.Buffer
+	if err := d.write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	streams := checkPDF(t, b.Bytes())
+	if len(streams) < 2 {
+		t.Fatalf("expected more than 1 page, got %d", len(streams))
+	}
+	if !bytes.Contains(b.Bytes(), []byte("/Count "+strconv.Itoa(len(streams))+" >>")) {
+		t.Errorf("expected the page tree to count %d pages", len(streams))
+	}
+	for i, stream := range streams {
+		if footer := "(Page " + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(streams)) + ") Tj"; !strings.Contains(stream, footer) {
+			t.Errorf("page %d: expected the footer %q", i+1, footer)
+		}
+	}
+	if !strings.Contains(streams[len(streams)-1], "(Line 199) Tj") {
+		t.Error("expected the last line on the last page")
+	}
+}
+
+// TestReportWritePDF checks that a report with findings is a valid document
+func TestReportWritePDF(t *testing.T) {
+	s := Scan{
+		ScanName:      "Backend (main)",
+		SemgrepOutput: `{"version": "1.85.0", "results": []}`,
+		Findings: []Finding{
+			{RuleID: "python.lang.security.audit.eval", Path: `app\main.py`, StartLine: 3, Severity: "ERROR", Message: "Detected eval(data)", Lines: "    eval(data)"},
+			{RuleID: "python.flask.security.xss", Path: "app/views.py", StartLine: 7, Severity: "WARNING", Message: "Ünïcödé ☃", TriageStatus: TriageWontFix},
+		},
+	}
+
+	report, err := s.NewReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := report.WritePDF(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Join(checkPDF(t, b.Bytes()), "\n")
+	for _, expected := range []string{
+		`(Semgrep report: Backend \(main\)) Tj`,
+		`(app\\main.py:3) Tj`,
+		`(Detected eval\(data\)) Tj`,
+		"(\xdcn\xefc\xf6d\xe9 ?) Tj",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("expected %q in the content streams", expected)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package semgrep
+
+import "os/exec"
+
+// setProcessGroup is a no-op on platforms without process groups,
+// only the command itself is killed when its context is done
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,227 @@
+package semgrep
+
+import (
+	"bagel/internal/logger"
+	"bagel/internal/random"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrProjectNotFound  = errors.New("project not found")
+	ErrProjectExists    = errors.New("a project with this name already exists")
+	ErrEmptyProjectName = errors.New("name cannot be empty")
+)
+
+// Project groups the scans of the same codebase
+type Project struct {
+	ID          uuid.UUID   `gorm:"type:text;primaryKey;"` // The UUID of the project
+	Name        string      `gorm:"type:text;uniqueIndex"` // The name defined by the user
+	Description string      `gorm:"type:text"`             // An optional description of the codebase
+	Rulesets    RulesetList `gorm:"type:text"`             // The rulesets preselected for new scans of the project
+	CreatedAt   time.Time   // The timestamp the project was created
+}
+
+// FindingCounts are the number of findings of a scan by severity and triage status
+type FindingCounts struct {
+	Total      int            // The number of findings
+	Open       int            // The number of findings that are not triaged as false positive, won't fix or fixed
+	BySeverity map[string]int // The number of findings per severity
+	ByTriage   map[string]int // The number of findings per triage status
+}
+
+// ProjectSummary is a project together with its latest finished scan
+type ProjectSummary struct {
+	Project
+	ScanCount  int64          // The number of scans of the project
+	LatestScan *Scan          // The latest scan that is done, nil if there is none
+	Counts     *FindingCounts // The findings of the latest scan, nil if there is none
+}
+
+// CreateProject stores a new project with the given default rulesets
+func CreateProject(db *gorm.DB, name string, description string, rulesets RulesetList) (project *Project, err error) {
+	if name == "" {
+		return nil, ErrEmptyProjectName
+	}
+
+	if err := checkProjectName(db, name, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	project = &Project{
+		ID:          random.UUID(),
+		Name:        name,
+		Description: description,
+		Rulesets:    rulesets,
+		CreatedAt:   time.Now(),
+	}
+	if err := db.Create(project).Error; err != nil {
+		return nil, err
+	}
+	logger.Info("Created project '%s'", name)
+
+	return project, nil
+}
+
+// UpdateProject changes the name, description and default rulesets of a project
+func UpdateProject(db *gorm.DB, project *Project) (err error) {
+	if project.Name == "" {
+		return ErrEmptyProjectName
+	}
+
+	if err := checkProjectName(db, project.Name, project.ID); err != nil {
+		return err
+	}
+
+	result := db.Model(project).Select("name", "description", "rulesets").Updates(project)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrProjectNotFound
+	}
+
+	return nil
+}
+
+// checkProjectName returns ErrProjectExists if another project already uses the name
+func checkProjectName(db *gorm.DB, name string, id uuid.UUID) (err error) {
+	var count int64
+	if err := db.Model(&Project{}).Where("name = ? AND id != ?", name, id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrProjectExists
+	}
+
+	return nil
+}
+
+// FindProject returns the project with the given ID
+func FindProject(db *gorm.DB, id uuid.UUID) (project *Project, err error) {
+	project = &Project{}
+	result := db.Limit(1).Find(project, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return project, nil
+}
+
+// ListProjects returns all projects ordered by name
+func ListProjects(db *gorm.DB) (projects []Project, err error) {
+	err = db.Order("name").Find(&projects).Error
+	return projects, err
+}
+
+// DeleteProject removes a project, its scans are kept without a project
+func DeleteProject(db *gorm.DB, id uuid.UUID) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&Project{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrProjectNotFound
+		}
+
+		if err := tx.Model(&Scan{}).Where("project_id = ?", id).Update("project_id", nil).Error; err != nil {
+			return err
+		}
+
+		logger.Info("Deleted project %s", id.String())
+		return nil
+	})
+}
+
+// Summarize returns the number of scans and the findings of the latest scan that is done
+func (p *Project) Summarize(db *gorm.DB) (summary *ProjectSummary, err error) {
+	summary = &ProjectSummary{Project: *p}
+	if err := db.Model(&Scan{}).Where("project_id = ?", p.ID).Count(&summary.ScanCount).Error; err != nil {
+		return nil, err
+	}
+
+	var scans []Scan
+	err = db.Omit("semgrep_output").
+		Where("project_id = ? AND status = ?", p.ID, StatusDone).
+		Order("finished_at desc").
+		Limit(1).
+		Find(&scans).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(scans) == 0 {
+		return summary, nil
+	}
+
+	summary.LatestScan = &scans[0]
+	summary.Counts, err = CountFindings(db, scans[0].ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// CountFindings counts the findings of a scan by severity and triage status
+func CountFindings(db *gorm.DB, scanID uuid.UUID) (counts *FindingCounts, err error) {
+	var rows []struct {
+		Severity     string
+		TriageStatus string
+		Count        int
+	}
+	err = db.Model(&Finding{}).
+		Select("severity, triage_status, count(*) AS count").
+		Where("scan_id = ?", scanID).
+		Group("severity, triage_status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts = &FindingCounts{BySeverity: map[string]int{}, ByTriage: map[string]int{}}
+	for _, row := range rows {
+		counts.Total += row.Count
+		counts.BySeverity[row.Severity] += row.Count
+		counts.ByTriage[row.TriageStatus] += row.Count
+		if !slices.Contains(closedTriageStatuses, row.TriageStatus) {
+			counts.Open += row.Count
+		}
+	}
+
+	return counts, nil
+}
+
+// Scans returns the scans of the project, newest first, together with the number of findings per scan
+func (p *Project) Scans(db *gorm.DB) (scans []Scan, findings map[uuid.UUID]int, err error) {
+	if err := db.Omit("semgrep_output").Where("project_id = ?", p.ID).Order("upload_date desc").Find(&scans).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var rows []struct {
+		ScanID uuid.UUID
+		Count  int
+	}
+	err = db.Model(&Finding{}).
+		Select("scan_id, count(*) AS count").
+		Where("scan_id IN (?)", db.Model(&Scan{}).Select("id").Where("project_id = ?", p.ID)).
+		Group("scan_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	findings = map[uuid.UUID]int{}
+	for _, row := range rows {
+		findings[row.ScanID] = row.Count
+	}
+
+	return scans, findings, nil
+}
@@ -0,0 +1,254 @@
+package semgrep
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The status of a scan in the queue
+const (
+	StatusQueued    = "queued"    // Waiting for a worker
+	StatusRunning   = "running"   // Claimed by a worker
+	StatusDone      = "done"      // Finished without errors
+	StatusFailed    = "failed"    // Finished with an error
+	StatusCancelled = "cancelled" // Cancelled by the user
+)
+
+var (
+	// All statuses a scan can have
+	Statuses = []string{StatusQueued, StatusRunning, StatusDone, StatusFailed, StatusCancelled}
+
+	ErrScanNotFound = errors.New("scan not found")
+	ErrScanFinished = errors.New("scan already finished")
+)
+
+// IsFinished checks if the scan has reached a final status
+func (s *Scan) IsFinished() bool {
+	return s.Status == StatusDone || s.Status == StatusFailed || s.Status == StatusCancelled
+}
+
+// AddToQueue stores the given scan as queued in the database and notifies the workers
+func (s *Scan) AddToQueue(db *gorm.DB) (err error) {
+	s.log().Info("Adding scan to queue")
+
+	s.Status = StatusQueued
+	if err := db.Create(s).Error; err != nil {
+		return fmt.Errorf("error adding scan %s to queue: %s", s.ID.String(), err)
+	}
+
+	s.publish()
+	notifyWorkers()
+	return nil
+}
+
+// notifyWorkers wakes up an idle worker without blocking if all workers are busy
+func notifyWorkers() {
+	select {
+	case chanJobs <- struct{}{}:
+	default:
+	}
+}
+
+// claimJob claims the oldest queued scan for the calling worker. Returns nil if the queue is empty.
+// The status is only changed if the scan is still queued, so each scan is claimed by exactly one worker
+func claimJob(db *gorm.DB) (job *Scan, err error) {
+	for {
+		var scans []Scan
+		if err := db.Where("status = ?", StatusQueued).Order("upload_date asc").Limit(1).Find(&scans).Error; err != nil {
+			return nil, err
+		}
+		if len(scans) == 0 {
+			return nil, nil
+		}
+		scan := scans[0]
+
+		now := time.Now()
+		result := db.Model(&Scan{}).
+			Where("id = ? AND status = ?", scan.ID, StatusQueued).
+			Updates(map[string]interface{}{"status": StatusRunning, "started_at": now})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			scan.Status = StatusRunning
+			scan.StartedAt = now
+			return &scan, nil
+		}
+
+		// Another worker was faster, try the next scan
+	}
+}
+
+// finish stores the result of a scan run by a worker. Only updates scans that are still running,
+// so a scan that was deleted in the meantime is not inserted again
+func (s *Scan) finish(db *gorm.DB, errScan error) (err error) {
+	s.FinishedAt = time.Now()
+	s.Status = StatusDone
+	if errScan == ErrScanCancelled {
+		s.Status = StatusCancelled
+	} else if errScan != nil {
+		s.Status = StatusFailed
+		s.Error = errScan.Error()
+	}
+	s.Stage = ""
+
+	updated := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(s).
+			Where("status = ?", StatusRunning).
+			Select("status", "stage", "error", "semgrep_output", "rule_sources", "git_commit", "finished_at").
+			Updates(s)
+		if result.Error != nil {
+			return result.Error
+		}
+		updated = result.RowsAffected > 0
+
+		// Findings are only stored for scans that are done and still exist
+		if !updated || s.Status != StatusDone || len(s.Findings) == 0 {
+			return nil
+		}
+
+		if err := s.carryTriage(tx); err != nil {
+			return err
+		}
+
+		return tx.CreateInBatches(s.Findings, findingsBatchSize).Error
+	})
+
+	// Subscribers learn about the result once it can be loaded
+	if err == nil && updated {
+		s.observeFinished()
+		s.publish()
+	}
+
+	return err
+}
+
+// Cancel cancels a queued or running scan. Queued scans are removed from the queue and their upload is removed,
+// running scans are stopped by killing Semgrep and are marked as cancelled by their worker
+func Cancel(db *gorm.DB, id uuid.UUID) (err error) {
+	// The status can change between loading and updating the scan, so retry a few times
+	for attempt := 0; attempt < 3; attempt++ {
+		var scan Scan
+		result := db.Limit(1).Find(&scan, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrScanNotFound
+		}
+
+		switch scan.Status {
+		case StatusQueued:
+			scan.FinishedAt = time.Now()
+			result := db.Model(&scan).
+				Where("status = ?", StatusQueued).
+				Updates(map[string]interface{}{"status": StatusCancelled, "finished_at": scan.FinishedAt})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 1 {
+				scan.log().Info("Removed scan from queue")
+				scan.Status = StatusCancelled
+				scan.observeFinished()
+				scan.publish()
+				scan.cleanup()
+				return nil
+			}
+
+		case StatusRunning:
+			runningJobsMu.Lock()
+			cancelCause, ok := runningJobs[id]
+			runningJobsMu.Unlock()
+
+			if ok {
+				scan.log().Info("Cancelling running scan")
+				cancelCause(ErrScanCancelled)
+				return nil
+			}
+
+			// Not running in this process, so no worker will update it anymore
+			scan.FinishedAt = time.Now()
+			result := db.Model(&scan).
+				Where("status = ?", StatusRunning).
+				Updates(map[string]interface{}{"status": StatusCancelled, "stage": "", "finished_at": scan.FinishedAt})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 1 {
+				scan.Status = StatusCancelled
+				scan.observeFinished()
+				scan.publish()
+				scan.cleanup()
+				return nil
+			}
+
+		default:
+			return ErrScanFinished
+		}
+	}
+
+	return fmt.Errorf("error cancelling scan %s: status keeps changing", id.String())
+}
+
+// requeue adds a scan interrupted by stopping the workers to the queue again, unless it was cancelled or deleted meanwhile
+func (s *Scan) requeue(db *gorm.DB) (err error) {
+	result := db.Model(&Scan{}).
+		Where("id = ? AND status = ?", s.ID, StatusRunning).
+		Updates(map[string]interface{}{"status": StatusQueued, "stage": "", "started_at": time.Time{}})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		s.Status = StatusQueued
+		s.Stage = ""
+		s.StartedAt = time.Time{}
+		s.publish()
+	}
+
+	return nil
+}
+
+// recoverJobs handles scans that were running when Bagel stopped. They are queued again if
+// the upload is still available and marked as failed otherwise
+func recoverJobs(db *gorm.DB) (err error) {
+	var scans []Scan
+	if err := db.Where("status = ?", StatusRunning).Find(&scans).Error; err != nil {
+		return err
+	}
+
+	for _, scan := range scans {
+		// Remove any leftovers of the interrupted run
+		for _, dir := range []string{scan.UnpackedPath, scan.rulesPath()} {
+			if err := os.RemoveAll(dir); err != nil {
+				scan.log().ErrorF("error removing directory %s: %s", dir, err)
+			}
+		}
+
+		_, errStat := os.Stat(scan.UploadPath)
+		if scan.GitURL != "" || errStat == nil {
+			scan.log().Warning("Scan was interrupted, adding it to the queue again")
+			err = db.Model(&scan).Updates(map[string]interface{}{"status": StatusQueued, "stage": "", "started_at": time.Time{}}).Error
+		} else {
+			scan.log().Warning("Scan was interrupted and its upload is gone, marking it as failed")
+			err = db.Model(&scan).Updates(map[string]interface{}{
+				"status":      StatusFailed,
+				"stage":       "",
+				"error":       "scan was interrupted by a restart and the upload is no longer available",
+				"finished_at": time.Now(),
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package semgrep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The vulnerability class of findings whose rule does not name one
+	otherVulnerabilityClass = "Other"
+)
+
+// Report is the content of a report on a scan that is done, for readers who do not use Bagel
+type Report struct {
+	Scan           *Scan
+	SemgrepVersion string
+	GeneratedAt    time.Time
+	Counts         *FindingCounts
+	Files          int           // The number of files with findings
+	Severities     []ReportCount // The findings by severity, most severe first
+	Classes        []ReportCount // The findings by vulnerability class, most findings first
+	Findings       []Finding     // The findings, most severe first and then by location
+}
+
+// ReportCount is a row of the executive summary of a report
+type ReportCount struct {
+	Name  string
+	Total int
+	Open  int
+}
+
+// SemgrepVersion returns the version of Semgrep that ran the scan, empty if the output does not contain it
+func (s *Scan) SemgrepVersion() (version string, err error) {
+	if s.SemgrepOutput == "" {
+		return "", nil
+	}
+
+	var output semgrepResults
+	if err := json.Unmarshal([]byte(s.SemgrepOutput), &output); err != nil {
+		return "", fmt.Errorf("error unmarshalling JSON for scan %s: %s", s.ID.String(), err)
+	}
+
+	return output.Version, nil
+}
+
+// NewReport summarizes the findings of the scan for a report. The findings have to be loaded first
+func (s *Scan) NewReport() (report *Report, err error) {
+	version, err := s.SemgrepVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	report = &Report{
+		Scan:           s,
+		SemgrepVersion: version,
+		GeneratedAt:    time.Now(),
+		Counts:         &FindingCounts{BySeverity: map[string]int{}, ByTriage: map[string]int{}},
+		Findings:       make([]Finding, len(s.Findings)),
+	}
+	copy(report.Findings, s.Findings)
+
+	severities := map[string]*ReportCount{}
+	classes := map[string]*ReportCount{}
+	files := map[string]bool{}
+	count := func(counts map[string]*ReportCount, name string, open bool) {
+		if counts[name] == nil {
+			counts[name] = &ReportCount{Name: name}
+		}
+		counts[name].Total++
+		if open {
+			counts[name].Open++
+		}
+	}
+
+	for i := range s.Findings {
+		f := &s.Findings[i]
+		open := f.TriageOpen()
+
+		report.Counts.Total++
+		report.Counts.BySeverity[f.Severity]++
+		report.Counts.ByTriage[f.TriageStatus]++
+		if open {
+			report.Counts.Open++
+		}
+		files[f.Path] = true
+
+		count(severities, f.Severity, open)
+		if len(f.Meta.VulnerabilityClass.Value) == 0 {
+			count(classes, otherVulnerabilityClass, open)
+		}
+		for _, class := range f.Meta.VulnerabilityClass.Value {
+			count(classes, class, open)
+		}
+	}
+	report.Files = len(files)
+
+	for _, c := range severities {
+		report.Severities = append(report.Severities, *c)
+	}
+	sort.Slice(report.Severities, func(i, j int) bool {
+		a, b := report.Severities[i], report.Severities[j]
+		if severityRank(a.Name) != severityRank(b.Name) {
+			return severityRank(a.Name) < severityRank(b.Name)
+		}
+		return a.Name < b.Name
+	})
+
+	for _, c := range classes {
+		report.Classes = append(report.Classes, *c)
+	}
+	sort.Slice(report.Classes, func(i, j int) bool {
+		a, b := report.Classes[i], report.Classes[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+
+	// The findings are loaded ordered by location, so a stable sort keeps that order within a severity
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return severityRank(report.Findings[i].Severity) < severityRank(report.Findings[j].Severity)
+	})
+
+	return report, nil
+}
+
+// severityRank orders the severities of Semgrep rules, the most severe first
+func severityRank(severity string) int {
+	switch strings.ToUpper(severity) {
+	case "CRITICAL":
+		return 0
+	case "ERROR", "HIGH":
+		return 1
+	case "WARNING", "MEDIUM":
+		return 2
+	case "INFO", "LOW":
+		return 3
+	default:
+		return 4
+	}
+}
+
+// WritePDF renders the report as a PDF file with the executive summary followed by all findings
+func (r *Report) WritePDF(w io.Writer) error {
+	d := newPDFDocument("Semgrep report: " + r.Scan.ScanName)
+
+	d.paragraph(pdfBold, 20, pdfBlack, 0, "Semgrep report: "+r.Scan.ScanName)
+	d.space(6)
+	for _, detail := range r.Details() {
+		d.paragraph(pdfRegular, 9, pdfGray, 0, detail)
+	}
+	d.line(pdfGray)
+
+	d.space(8)
+	d.paragraph(pdfBold, 14, pdfBlack, 0, "Executive summary")
+	d.space(4)
+	d.paragraph(pdfRegular, 10, pdfBlack, 0, r.Summary())
+	for _, table := range []struct {
+		title  string
+		counts []ReportCount
+	}{{"Severity", r.Severities}, {"Vulnerability class", r.Classes}} {
+		if len(table.counts) == 0 {
+			continue
+		}
+
+		d.space(8)
+		offsets := []float64{0, 300, 380}
+		d.ensure(40)
+		d.row(pdfBold, 10, offsets, table.title, "Findings", "Open")
+		for _, c := range table.counts {
+			d.row(pdfRegular, 10, offsets, c.Name, strconv.Itoa(c.Total), strconv.Itoa(c.Open))
+		}
+	}
+
+	if len(r.Findings) > 0 {
+		d.space(12)
+		d.paragraph(pdfBold, 14, pdfBlack, 0, "Findings")
+	}
+	for i := range r.Findings {
+		f := &r.Findings[i]
+
+		d.space(6)
+		d.ensure(60)
+		d.line(pdfGray)
+		d.paragraph(pdfBold, 11, pdfSeverityColor(f.Severity), 0, f.Severity+"  "+f.RuleID)
+		d.paragraph(pdfRegular, 9, pdfGray, 0, fmt.Sprintf("%s:%d", f.Path, f.StartLine))
+		if classes := f.Meta.VulnerabilityClass.Value; len(classes) > 0 {
+			d.paragraph(pdfRegular, 9, pdfGray, 0, strings.Join(classes, ", "))
+		}
+		d.space(2)
+		d.paragraph(pdfRegular, 10, pdfBlack, 0, f.Message)
+		d.space(2)
+		d.code(8, f.Lines)
+		d.space(2)
+		for _, cwe := range f.Meta.Cwe.Value {
+			d.paragraph(pdfRegular, 9, pdfBlack, 0, cwe)
+		}
+		for _, owasp := range f.Meta.Owasp.Value {
+			d.paragraph(pdfRegular, 9, pdfBlack, 0, "OWASP "+owasp)
+		}
+		if triage := f.TriageSummary(); triage != "" {
+			d.paragraph(pdfRegular, 9, pdfBlack, 0, "Triage: "+triage)
+		}
+	}
+
+	return d.write(w)
+}
+
+// Summary describes the findings of the report as the start of the executive summary
+func (r *Report) Summary() string {
+	if r.Counts.Total == 0 {
+		return "Semgrep found no issues."
+	}
+
+	return fmt.Sprintf("Semgrep found %s in %s, %d of them open. Findings triaged as false positive, won't fix or fixed are not open.",
+		plural(r.Counts.Total, "finding"), plural(r.Files, "file"), r.Counts.Open)
+}
+
+// Details returns the lines describing the scanned codebase and the scan
+func (r *Report) Details() (details []string) {
+	s := r.Scan
+	if s.GitURL != "" {
+		details = append(details, "Repository: "+s.GitURL)
+		if s.GitCommit != "" {
+			commit := "Commit: " + s.GitCommit
+			if s.GitRef != "" {
+				commit += " (" + s.GitRef + ")"
+			}
+			details = append(details, commit)
+		}
+	} else {
+		details = append(details, "File: "+s.UploadName)
+	}
+	details = append(details, "Rulesets: "+s.Rulesets.String(), "Scanned: "+s.UploadDate.Format("2006-01-02 15:04:05"))
+	if r.SemgrepVersion != "" {
+		details = append(details, "Semgrep version: "+r.SemgrepVersion)
+	}
+
+	return append(details, "Generated: "+r.GeneratedAt.Format("2006-01-02 15:04:05"))
+}
+
+// TriageSummary describes the triage decision for a finding, empty if it is untriaged
+func (f *Finding) TriageSummary() string {
+	if f.TriageStatus == TriageUntriaged || f.TriageStatus == "" {
+		return ""
+	}
+
+	summary := f.TriageLabel()
+	if f.TriagedBy != "" {
+		summary += " by " + f.TriagedBy + " on " + f.TriagedAt.Format("2006-01-02")
+	}
+	if f.TriageComment != "" {
+		summary += ": " + f.TriageComment
+	}
+
+	return summary
+}
+
+// pdfSeverityColor returns the color a severity is highlighted with
+func pdfSeverityColor(severity string) pdfColor {
+	switch severityRank(severity) {
+	case 0, 1:
+		return pdfColor{0.75, 0.1, 0.1}
+	case 2:
+		return pdfColor{0.8, 0.45, 0}
+	default:
+		return pdfColor{0.1, 0.35, 0.7}
+	}
+}
+
+// plural returns the count with the noun in singular or plural
+func plural(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+
+	return strconv.Itoa(count) + " " + noun + "s"
+}
@@ -3,28 +3,43 @@ package semgrep
 import "encoding/json"
 
 type Result struct {
-	CheckID string `json:"check_id"`
+	CheckID string   `json:"check_id"`
+	Path    string   `json:"path"`
+	Start   Position `json:"start"`
+	End     Position `json:"end"`
 	Extra   struct {
-		Lines    string `json:"lines"`
-		Message  string `json:"message"`
-		Metadata struct {
-			Asvs struct {
-				ControlID  string `json:"control_id"`
-				ControlURL string `json:"control_url"`
-				Section    string `json:"section"`
-				Version    string `json:"version"`
-			} `json:"asvs"`
-			Category           string              `json:"category" `
-			Confidence         string              `json:"confidence"`
-			Cwe                StringOrStringSlice `json:"cwe"`
-			Impact             string              `json:"impact"`
-			Likelihood         string              `json:"likelihood"`
-			Owasp              StringOrStringSlice `json:"owasp" `
-			References         StringOrStringSlice `json:"references"`
-			VulnerabilityClass StringOrStringSlice `json:"vulnerability_class"`
-		} `json:"metadata"`
+		Lines        string          `json:"lines"`
+		Message      string          `json:"message"`
+		Severity     string          `json:"severity"`
+		Fingerprint  string          `json:"fingerprint"`
+		MatchBasedID string          `json:"match_based_id"`
+		IsIgnored    bool            `json:"is_ignored"`
+		Metadata     json.RawMessage `json:"metadata"`
 	} `json:"extra"`
-	Path string `json:"path"`
+}
+
+type Position struct {
+	Line int `json:"line"`
+	Col  int `json:"col"`
+}
+
+// Metadata is the metadata of a Semgrep rule, only the parts used by Bagel
+type Metadata struct {
+	Asvs struct {
+		ControlID  string `json:"control_id"`
+		ControlURL string `json:"control_url"`
+		Section    string `json:"section"`
+		Version    string `json:"version"`
+	} `json:"asvs"`
+	Category           string              `json:"category" `
+	Confidence         string              `json:"confidence"`
+	Cwe                StringOrStringSlice `json:"cwe"`
+	Impact             string              `json:"impact"`
+	Likelihood         string              `json:"likelihood"`
+	Owasp              StringOrStringSlice `json:"owasp" `
+	References         StringOrStringSlice `json:"references"`
+	Source             string              `json:"source"`
+	VulnerabilityClass StringOrStringSlice `json:"vulnerability_class"`
 }
 
 type StringOrStringSlice struct {
@@ -56,3 +71,12 @@ func (s *StringOrStringSlice) UnmarshalJSON(b []byte) error {
 	s.Set = true
 	return nil
 }
+
+// The MarshalJSON method on StringOrStringSlice will always marshal the value as an array of strings
+func (s StringOrStringSlice) MarshalJSON() ([]byte, error) {
+	if s.Value == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(s.Value)
+}
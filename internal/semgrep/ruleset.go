@@ -1,14 +1,46 @@
 package semgrep
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"gorm.io/gorm"
+)
+
 var (
 	// Global variable to store the rulesets
 	Rulesets map[string]Ruleset
+
+	ErrRulesetNotFound = errors.New("ruleset not found")
+	ErrNoRulesets      = errors.New("at least one ruleset is required")
 )
 
 type Ruleset struct {
-	Name string `gorm:"type:text"`
+	Name   string `json:"name"`
+	Custom bool   `json:"custom"`          // If the ruleset was uploaded by a user instead of coming from the registry
+	Local  bool   `json:"local,omitempty"` // If the ruleset is a file in the rules directory
 }
 
+// RulesetInfo describes a ruleset that can be selected for a scan
+type RulesetInfo struct {
+	Ruleset
+	ID        uuid.UUID // The ID of a custom ruleset
+	RuleCount int       // The number of rules, 0 for registry rulesets as it is unknown
+	UpdatedAt time.Time // When the rules were uploaded or the file changed, zero for registry rulesets
+}
+
+// RulesetList are the rulesets of a scan, stored as JSON as sqlite does not have support for arrays
+type RulesetList []Ruleset
+
 // Register the default rulesets
 func init() {
 	Rulesets = map[string]Ruleset{
@@ -29,3 +61,185 @@ func init() {
 func (r *Ruleset) URL() string {
 	return "p/" + r.Name
 }
+
+// ListRulesets returns all rulesets that can be selected for a scan: the local rulesets, the registry rulesets
+// that are not shadowed by a local ruleset of the same name unless offline, and the custom rulesets
+func ListRulesets(db *gorm.DB) (rulesets []RulesetInfo, err error) {
+	locals, err := ListLocalRulesets()
+	if err != nil {
+		return nil, err
+	}
+
+	shadowed := map[string]bool{}
+	for _, local := range locals {
+		shadowed[local.Name] = true
+		rulesets = append(rulesets, RulesetInfo{
+			Ruleset:   Ruleset{Name: local.Name, Local: true},
+			RuleCount: local.RuleCount,
+			UpdatedAt: local.UpdatedAt,
+		})
+	}
+
+	if !rulesOffline {
+		var registry []RulesetInfo
+		for name, ruleset := range Rulesets {
+			if !shadowed[name] {
+				registry = append(registry, RulesetInfo{Ruleset: ruleset})
+			}
+		}
+		sort.Slice(registry, func(i, j int) bool { return registry[i].Name < registry[j].Name })
+		rulesets = append(rulesets, registry...)
+	}
+
+	customs, err := ListCustomRulesets(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, custom := range customs {
+		rulesets = append(rulesets, RulesetInfo{
+			Ruleset:   Ruleset{Name: custom.Name, Custom: true},
+			ID:        custom.ID,
+			RuleCount: custom.RuleCount,
+			UpdatedAt: custom.UploadDate,
+		})
+	}
+
+	return rulesets, nil
+}
+
+// FindRuleset returns the local, registry or custom ruleset with the given name, in this order
+func FindRuleset(db *gorm.DB, name string) (ruleset Ruleset, err error) {
+	if _, _, err := findLocalRuleset(name); err == nil {
+		return Ruleset{Name: name, Local: true}, nil
+	} else if !errors.Is(err, ErrRulesetNotFound) {
+		return Ruleset{}, err
+	}
+
+	if ruleset, ok := Rulesets[name]; ok && !rulesOffline {
+		return ruleset, nil
+	}
+
+	custom, err := findCustomRuleset(db, "name = ?", name)
+	if err != nil {
+		return Ruleset{}, err
+	}
+
+	return Ruleset{Name: custom.Name, Custom: true}, nil
+}
+
+// FindRulesets returns the rulesets with the given names, duplicates are removed
+func FindRulesets(db *gorm.DB, names []string) (rulesets RulesetList, err error) {
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		ruleset, err := FindRuleset(db, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, name)
+		}
+		rulesets = append(rulesets, ruleset)
+	}
+
+	if len(rulesets) == 0 {
+		return nil, ErrNoRulesets
+	}
+
+	return rulesets, nil
+}
+
+// config returns the value for the --config parameter in Semgrep and the IDs of the rules if they are known.
+// Local and custom rulesets are copied into dir, the returned path is relative to dir
+// so Semgrep does not prefix the rule IDs with the directory
+func (r *Ruleset) config(db *gorm.DB, dir string) (config string, ruleIDs []string, err error) {
+	if r.Local {
+		local, ruleIDs, err := findLocalRuleset(r.Name)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %s", err, r.Name)
+		}
+
+		rules, err := os.ReadFile(local.Path) // #nosec G304, the path is inside the rules directory
+		if err != nil {
+			return "", nil, err
+		}
+
+		// The name is safe to use in the path as local ruleset names are validated
+		config = "local-" + r.Name + ".yaml"
+		if err := os.WriteFile(filepath.Join(dir, config), rules, 0o600); err != nil {
+			return "", nil, err
+		}
+
+		return config, ruleIDs, nil
+	}
+
+	if !r.Custom {
+		return r.URL(), nil, nil
+	}
+
+	custom, err := findCustomRuleset(db, "name = ?", r.Name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s", err, r.Name)
+	}
+
+	ruleIDs, err = parseRuleIDs([]byte(custom.Rules))
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The name is not used in the path as it is user controlled
+	config = custom.ID.String() + ".yaml"
+	if err := os.WriteFile(filepath.Join(dir, config), []byte(custom.Rules), 0o600); err != nil {
+		return "", nil, err
+	}
+
+	return config, ruleIDs, nil
+}
+
+// Names returns the names of the rulesets
+func (l RulesetList) Names() (names []string) {
+	for _, ruleset := range l {
+		names = append(names, ruleset.Name)
+	}
+
+	return names
+}
+
+// String returns the names of the rulesets separated by commas
+func (l RulesetList) String() string {
+	return strings.Join(l.Names(), ", ")
+}
+
+// Value stores the rulesets as JSON
+func (l RulesetList) Value() (driver.Value, error) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Scan reads the rulesets from JSON
+func (l *RulesetList) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported type %T for rulesets", value)
+	}
+
+	if len(b) == 0 {
+		*l = nil
+		return nil
+	}
+
+	return json.Unmarshal(b, l)
+}
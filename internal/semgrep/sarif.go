@@ -0,0 +1,297 @@
+package semgrep
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://docs.oasis-open.org/sarif/sarif/v2.1.0/errata01/os/schemas/sarif-schema-2.1.0.json"
+
+	// The base of the relative paths of the findings, the root of the scanned codebase
+	sarifSourceRoot = "%SRCROOT%"
+)
+
+var (
+	// The number of a CWE like "CWE-79: Improper Neutralization of Input During Web Page Generation"
+	cweNumber = regexp.MustCompile(`^CWE-(\d+)`)
+)
+
+// The subset of the SARIF 2.1.0 object model that Bagel exports
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+
+	sarifRun struct {
+		Tool                     sarifTool                    `json:"tool"`
+		Results                  []sarifResult                `json:"results"`
+		VersionControlProvenance []sarifVersionControlDetails `json:"versionControlProvenance,omitempty"`
+	}
+
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+
+	sarifDriver struct {
+		Name            string      `json:"name"`
+		SemanticVersion string      `json:"semanticVersion,omitempty"`
+		InformationURI  string      `json:"informationUri"`
+		Rules           []sarifRule `json:"rules"`
+	}
+
+	sarifRule struct {
+		ID                   string                 `json:"id"`
+		ShortDescription     sarifMessage           `json:"shortDescription"`
+		FullDescription      sarifMessage           `json:"fullDescription"`
+		Help                 sarifMessage           `json:"help"`
+		HelpURI              string                 `json:"helpUri,omitempty"`
+		DefaultConfiguration sarifRuleConfiguration `json:"defaultConfiguration"`
+		Properties           sarifRuleProperties    `json:"properties"`
+	}
+
+	sarifRuleConfiguration struct {
+		Level string `json:"level"`
+	}
+
+	sarifRuleProperties struct {
+		Tags      []string `json:"tags"`
+		Precision string   `json:"precision,omitempty"`
+	}
+
+	sarifMessage struct {
+		Text     string `json:"text"`
+		Markdown string `json:"markdown,omitempty"`
+	}
+
+	sarifResult struct {
+		RuleID              string             `json:"ruleId"`
+		RuleIndex           int                `json:"ruleIndex"`
+		Level               string             `json:"level"`
+		Message             sarifMessage       `json:"message"`
+		Locations           []sarifLocation    `json:"locations"`
+		PartialFingerprints map[string]string  `json:"partialFingerprints,omitempty"`
+		Suppressions        []sarifSuppression `json:"suppressions,omitempty"`
+	}
+
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+
+	sarifArtifactLocation struct {
+		URI       string `json:"uri"`
+		URIBaseID string `json:"uriBaseId,omitempty"`
+	}
+
+	sarifRegion struct {
+		StartLine   int           `json:"startLine"`
+		StartColumn int           `json:"startColumn,omitempty"`
+		EndLine     int           `json:"endLine,omitempty"`
+		EndColumn   int           `json:"endColumn,omitempty"`
+		Snippet     *sarifMessage `json:"snippet,omitempty"`
+	}
+
+	sarifSuppression struct {
+		Kind          string `json:"kind"`
+		Status        string `json:"status,omitempty"`
+		Justification string `json:"justification,omitempty"`
+	}
+
+	sarifVersionControlDetails struct {
+		RepositoryURI string `json:"repositoryUri"`
+		RevisionID    string `json:"revisionId,omitempty"`
+		Branch        string `json:"branch,omitempty"`
+	}
+)
+
+// SARIF exports the findings of the scan as a SARIF 2.1.0 log. The findings have to be loaded first.
+// Results ignored with a nosemgrep comment and findings triaged as false positive or won't fix are suppressed
+func (s *Scan) SARIF() (b []byte, err error) {
+	version, err := s.SemgrepVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:            "Semgrep",
+			SemanticVersion: version,
+			InformationURI:  "https://semgrep.dev",
+			Rules:           []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	if s.GitURL != "" {
+		run.VersionControlProvenance = []sarifVersionControlDetails{{RepositoryURI: s.GitURL, RevisionID: s.GitCommit, Branch: s.GitRef}}
+	}
+
+	// One rule per rule ID, sorted so the indices are stable
+	ruleIndices := map[string]int{}
+	for i := range s.Findings {
+		ruleIndices[s.Findings[i].RuleID] = -1
+	}
+	ruleIDs := make([]string, 0, len(ruleIndices))
+	for id := range ruleIndices {
+		ruleIDs = append(ruleIDs, id)
+	}
+	sort.Strings(ruleIDs)
+	for i, id := range ruleIDs {
+		ruleIndices[id] = i
+	}
+	run.Tool.Driver.Rules = make([]sarifRule, len(ruleIDs))
+
+	for i := range s.Findings {
+		f := &s.Findings[i]
+		index := ruleIndices[f.RuleID]
+		if run.Tool.Driver.Rules[index].ID == "" {
+			run.Tool.Driver.Rules[index] = newSarifRule(f)
+		}
+
+		run.Results = append(run.Results, newSarifResult(f, index))
+	}
+
+	log := sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{run}}
+	return json.MarshalIndent(log, "", "  ")
+}
+
+// newSarifRule describes the rule of a finding, Semgrep only reports the message and the metadata of a rule
+func newSarifRule(f *Finding) sarifRule {
+	message := sarifText(f)
+
+	help := message
+	markdown := message
+	if len(f.Meta.References.Value) > 0 {
+		help += "\n\nReferences:"
+		markdown += "\n\n#### References"
+		for _, reference := range f.Meta.References.Value {
+			help += "\n- " + reference
+			markdown += "\n- [" + reference + "](" + reference + ")"
+		}
+	}
+
+	// Tags are shown and filterable on most platforms, the CWE tags follow the format of GitHub code scanning
+	var tags []string
+	addTag := func(tag string) {
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	addTag(f.Meta.Category)
+	for _, cwe := range f.Meta.Cwe.Value {
+		addTag(cwe)
+		if match := cweNumber.FindStringSubmatch(cwe); match != nil {
+			addTag("external/cwe/cwe-" + match[1])
+		}
+	}
+	for _, owasp := range f.Meta.Owasp.Value {
+		addTag("OWASP-" + owasp)
+	}
+	for _, class := range f.Meta.VulnerabilityClass.Value {
+		addTag(class)
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	rule := sarifRule{
+		ID:                   f.RuleID,
+		ShortDescription:     sarifMessage{Text: "Semgrep rule " + f.RuleID},
+		FullDescription:      sarifMessage{Text: message},
+		Help:                 sarifMessage{Text: help, Markdown: markdown},
+		DefaultConfiguration: sarifRuleConfiguration{Level: sarifLevel(f.Severity)},
+		Properties:           sarifRuleProperties{Tags: tags, Precision: sarifPrecision(f.Meta.Confidence)},
+	}
+
+	// Registry rules link to their page, otherwise the first reference is the most helpful
+	if strings.HasPrefix(f.Meta.Source, "https://") {
+		rule.HelpURI = f.Meta.Source
+	} else if len(f.Meta.References.Value) > 0 && strings.HasPrefix(f.Meta.References.Value[0], "https://") {
+		rule.HelpURI = f.Meta.References.Value[0]
+	}
+
+	return rule
+}
+
+// newSarifResult converts a finding into a result of the rule at the given index
+func newSarifResult(f *Finding, ruleIndex int) sarifResult {
+	location := sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: (&url.URL{Path: f.Path}).String(), URIBaseID: sarifSourceRoot},
+	}
+	if f.StartLine > 0 {
+		location.Region = &sarifRegion{StartLine: f.StartLine, StartColumn: f.StartCol, EndLine: f.EndLine, EndColumn: f.EndCol}
+		if f.Lines != "" {
+			location.Region.Snippet = &sarifMessage{Text: f.Lines}
+		}
+	}
+
+	result := sarifResult{
+		RuleID:    f.RuleID,
+		RuleIndex: ruleIndex,
+		Level:     sarifLevel(f.Severity),
+		Message:   sarifMessage{Text: sarifText(f)},
+		Locations: []sarifLocation{{PhysicalLocation: location}},
+	}
+	if f.Fingerprint != "" {
+		result.PartialFingerprints = map[string]string{"bagel/v1": f.Fingerprint}
+	}
+
+	if f.Ignored {
+		result.Suppressions = append(result.Suppressions, sarifSuppression{Kind: "inSource"})
+	}
+	if f.TriageStatus == TriageFalsePositive || f.TriageStatus == TriageWontFix {
+		justification := TriageLabel(f.TriageStatus)
+		if f.TriageComment != "" {
+			justification += ": " + f.TriageComment
+		}
+		result.Suppressions = append(result.Suppressions, sarifSuppression{Kind: "external", Status: "accepted", Justification: justification})
+	}
+
+	return result
+}
+
+// sarifText returns the message of a finding, SARIF requires a text for every message
+func sarifText(f *Finding) string {
+	if strings.TrimSpace(f.Message) == "" {
+		return f.RuleID
+	}
+
+	return f.Message
+}
+
+// sarifLevel maps the severity of a Semgrep rule to a SARIF level
+func sarifLevel(severity string) string {
+	switch strings.ToUpper(severity) {
+	case "ERROR", "CRITICAL", "HIGH":
+		return "error"
+	case "WARNING", "MEDIUM":
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// sarifPrecision maps the confidence of a Semgrep rule to a SARIF precision
+func sarifPrecision(confidence string) string {
+	switch strings.ToUpper(confidence) {
+	case "HIGH":
+		return "very-high"
+	case "MEDIUM":
+		return "high"
+	case "LOW":
+		return "medium"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,148 @@
+package semgrep
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// The official SARIF 2.1.0 schema, errata 01
+//
+//go:embed testdata/sarif-schema-2.1.0.json
+var sarifSchemaJSON []byte
+
+// TestSARIF checks that the export of normal, nosemgrep-suppressed and triaged findings is valid against the SARIF schema
+func TestSARIF(t *testing.T) {
+	eval := Metadata{Category: "security", Confidence: "HIGH"}
+	eval.Cwe.Value = []string{"CWE-95: Improper Neutralization of Directives in Dynamically Evaluated Code ('Eval Injection')"}
+	eval.References.Value = []string{"https://owasp.org/www-community/attacks/Code_Injection"}
+
+	xss := Metadata{Category: "security", Source: "https://semgrep.dev/r/python.flask.security.xss"}
+	xss.Cwe.Value = []string{"CWE-79: Improper Neutralization of Input During Web Page Generation ('Cross-site Scripting')"}
+	xss.Owasp.Value = []string{"A03:2021 - Injection"}
+
+	s := Scan{
+		ID:            uuid.New(),
+		SemgrepOutput: `{"version": "1.85.0", "results": []}`,
+		GitURL:        "https://example.com/bagel.git",
+		GitRef:        "main",
+		GitCommit:     "0123456789abcdef0123456789abcdef01234567",
+		Findings: []Finding{
+			{
+				RuleID: "python.lang.security.audit.eval", Path: "app/main.py", StartLine: 3, StartCol: 5, EndLine: 3, EndCol: 20,
+				Severity: "ERROR", Message: "Detected the use of eval()", Lines: "    eval(data)", Fingerprint: "a1", Meta: eval,
+			},
+			{
+				RuleID: "python.lang.security.audit.eval", Path: "app/legacy code.py", StartLine: 10, StartCol: 1, EndLine: 12, EndCol: 2,
+				Severity: "ERROR", Message: "Detected the use of eval()", Fingerprint: "a2", Ignored: true, Meta: eval,
+			},
+			{
+				RuleID: "python.flask.security.xss", Path: "app/views.py", StartLine: 7, StartCol: 12, EndLine: 7, EndCol: 40,
+				Severity: "WARNING", Message: "User input is rendered without escaping", Fingerprint: "b1",
+				TriageStatus: TriageFalsePositive, TriageComment: "The input is escaped by the template", Meta: xss,
+			},
+			{
+				RuleID: "python.flask.security.xss", Path: "app/admin.py", StartLine: 20, StartCol: 1, EndLine: 20, EndCol: 30,
+				Severity: "WARNING", Fingerprint: "b2", TriageStatus: TriageWontFix, Meta: xss,
+			},
+		},
+	}
+
+	b, err := s.SARIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validateSARIF(t, b)
+
+	var log sarifLog
+	if err := json.Unmarshal(b, &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 {
+		t.Fatalf("expected 1 run, got %d", len(log.Runs))
+	}
+	run := log.Runs[0]
+
+	// Rules are sorted by their ID and carry the CWE tags in the format of GitHub code scanning
+	if len(run.Tool.Driver.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(run.Tool.Driver.Rules))
+	}
+	for i, expected := range []struct {
+		id  string
+		tag string
+	}{
+		{"python.flask.security.xss", "external/cwe/cwe-79"},
+		{"python.lang.security.audit.eval", "external/cwe/cwe-95"},
+	} {
+		rule := run.Tool.Driver.Rules[i]
+		if rule.ID != expected.id {
+			t.Errorf("rule %d: expected ID %s, got %s", i, expected.id, rule.ID)
+		}
+		if !slices.Contains(rule.Properties.Tags, expected.tag) {
+			t.Errorf("rule %s: expected tag %s, got %v", rule.ID, expected.tag, rule.Properties.Tags)
+		}
+	}
+
+	// Only suppressed results carry suppressions, nosemgrep in source and triage decisions externally
+	expected := [][]sarifSuppression{
+		nil,
+		{{Kind: "inSource"}},
+		{{Kind: "external", Status: "accepted", Justification: "False positive: The input is escaped by the template"}},
+		{{Kind: "external", Status: "accepted", Justification: "Won't fix"}},
+	}
+	if len(run.Results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(run.Results))
+	}
+	for i, result := range run.Results {
+		if !slices.Equal(result.Suppressions, expected[i]) {
+			t.Errorf("result %d: expected suppressions %v, got %v", i, expected[i], result.Suppressions)
+		}
+		if rule := run.Tool.Driver.Rules[result.RuleIndex]; rule.ID != result.RuleID {
+			t.Errorf("result %d: rule index %d points to %s instead of %s", i, result.RuleIndex, rule.ID, result.RuleID)
+		}
+	}
+}
+
+// TestSARIFEmpty checks that a scan without findings is still a valid log
+func TestSARIFEmpty(t *testing.T) {
+	s := Scan{ID: uuid.New()}
+
+	b, err := s.SARIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validateSARIF(t, b)
+}
+
+// validateSARIF validates a SARIF log against the schema
+func validateSARIF(t *testing.T, b []byte) {
+	t.Helper()
+
+	schema, err := jsonschema.UnmarshalJSON(bytes.NewReader(sarifSchemaJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(sarifSchema, schema); err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compiler.Compile(sarifSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compiled.Validate(doc); err != nil {
+		t.Errorf("SARIF log does not match the schema: %s", err)
+	}
+}
@@ -2,73 +2,130 @@ package semgrep
 
 import (
 	"bagel/internal/logger"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"gorm.io/gorm"
 )
 
 // Scan represents a scan uploaded by the user
 type Scan struct {
-	ID            uuid.UUID `gorm:"type:text;primaryKey;"`            // The UUID of the scan
-	ScanName      string    `gorm:"type:text"`                        // The name of the scan defined by the user
-	Ruleset       Ruleset   `gorm:"embedded;embeddedPrefix:ruleset_"` // The ruleset used for the scan
-	UploadDate    time.Time // The timestamp the scan was uploaded
-	UploadName    string    `gorm:"type:text"`    // The name of the uploaded file, used for the front end
-	UploadPath    string    `gorm:"type:text"`    // The path to the uploaded file (removed after unpkacing)
-	UnpackedPath  string    `gorm:"type:text"`    // The path to the unpacked files
-	Finished      bool      `gorm:"type:boolean"` // If the scan has finished
-	Error         string    `gorm:"type:text"`    // If there were any errors during unpacking or scanning
-	SemgrepOutput string    `gorm:"type:text"`    // The Semgrep output as JSON
-	Results       []Result  `gorm:"-"`            // The parsed results (only used temporarily when rendering a page)
+	ID            uuid.UUID   `gorm:"type:text;primaryKey;"` // The UUID of the scan
+	ScanName      string      `gorm:"type:text"`             // The name of the scan defined by the user
+	ProjectID     uuid.UUID   `gorm:"type:text;index"`       // The project the scan belongs to, nil if it has none
+	Rulesets      RulesetList `gorm:"type:text"`             // The rulesets used for the scan
+	UploadDate    time.Time   // The timestamp the scan was uploaded
+	UploadName    string      `gorm:"type:text"`       // The name of the uploaded file or the Git URL, used for the front end
+	UploadPath    string      `gorm:"type:text"`       // The path to the uploaded file (removed after unpkacing)
+	GitURL        string      `gorm:"type:text"`       // The URL of the Git repository to clone instead of an uploaded file
+	GitRef        string      `gorm:"type:text"`       // The branch, tag or commit to check out, empty for the default branch
+	GitCommit     string      `gorm:"type:text"`       // The commit SHA the ref resolved to
+	UnpackedPath  string      `gorm:"type:text"`       // The path to the unpacked files
+	Status        string      `gorm:"type:text;index"` // The status of the scan in the queue, one of the Status constants
+	Stage         string      `gorm:"type:text"`       // The stage of a running scan, one of the Stage constants
+	StartedAt     time.Time   // The timestamp a worker started the scan
+	FinishedAt    time.Time   // The timestamp the scan finished
+	Error         string      `gorm:"type:text"` // If there were any errors during unpacking or scanning
+	SemgrepOutput string      `gorm:"type:text"` // The Semgrep output as JSON
+	RuleSources   string      `gorm:"type:text"` // The rulesets each rule ID of the results came from as JSON
+	RequestID     string      `gorm:"type:text"` // The ID of the request that created the scan, to follow it through the logs
+	Findings      []Finding   `gorm:"-"`         // The findings, only set after running the scan or loading them
 }
 
+const (
+	// How long to wait for the output pipes to close after Semgrep was killed
+	waitDelay = 5 * time.Second
+)
+
 type semgrepResults struct {
+	Version string   `json:"version"`
 	Results []Result `json:"results"`
 }
 
-// AddToQueue adds the given scan to the queue
-func (s *Scan) AddToQueue() {
-	logger.Info("Adding scan %s to queue", s.ID.String())
-	chanJobs <- s
-}
-
-// ParseResults parses the Semgrep output into the []Result struct of s.Results
-func (s *Scan) ParseResults() (err error) {
-	logger.Info("Parsing results for scan %s", s.ID.String())
+// runSemgrep runs Semgrep on a given scan, Semgrep and all of its child processes are killed once ctx is done
+func (s *Scan) runSemgrep(ctx context.Context, db *gorm.DB) (err error) {
+	defer func() {
+		// Scans interrupted by a shutdown run again after the next start, so their upload is kept
+		if err != nil && errors.Is(context.Cause(ctx), ErrWorkersStopped) {
+			s.removeDirs()
+			return
+		}
+		s.cleanup()
+	}()
 
-	semgrepResults := semgrepResults{}
-	if err := json.Unmarshal([]byte(s.SemgrepOutput), &semgrepResults); err != nil {
-		return fmt.Errorf("error unmarshalling JSON for scan %s: %s", s.ID.String(), err)
+	// Custom rules are written next to the unpacked files, Semgrep runs in that directory
+	if err := os.MkdirAll(s.rulesPath(), 0o700); err != nil {
+		return err
 	}
 
-	logger.Info("Got %d results for scan %s", len(semgrepResults.Results), s.ID.String())
-	s.Results = semgrepResults.Results
+	// Every ruleset is passed as its own --config, remember the rule IDs to attribute the results
+	args := []string{"scan", "-q", "--metrics", "off", "--json",
+		"--timeout", strconv.Itoa(semgrepTimeout),
+		"--max-memory", strconv.Itoa(semgrepMaxMemory),
+	}
+	ruleIDs := map[string][]string{}
+	for _, ruleset := range s.Rulesets {
+		config, ids, err := ruleset.config(db, s.rulesPath())
+		if err != nil {
+			return err
+		}
+		args = append(args, "--config", config)
+		ruleIDs[ruleset.Name] = ids
+	}
+	args = append(args, s.UnpackedPath)
 
-	return nil
-}
+	if err := s.setStage(db, StageUnpacking); err != nil {
+		return err
+	}
 
-// runSemgrep runs Semgrep on a given scan
-func (s *Scan) runSemgrep() (err error) {
-	defer s.cleanup()
+	start := time.Now()
+	if s.GitURL != "" {
+		// Clone the repository
+		if err := s.clone(ctx); err != nil {
+			return err
+		}
+	} else {
+		// Unpack the file
+		if err := s.unpack(ctx); err != nil {
+			return err
+		}
+	}
+	s.observeUnpack(start)
 
-	// Unpack the file
-	if err := s.unpack(); err != nil {
+	if err := s.setStage(db, StageScanning); err != nil {
 		return err
 	}
 
 	// Run semgrep on the unpacked directory
-	cmdSemgrep := exec.Command("semgrep", "scan", "-q", "--metrics", "off", "--json", "--config", s.Ruleset.URL(), s.UnpackedPath) // #nosec G204, UnpackedPath does not contain user controllable data
-	logger.Info("Running %s", cmdSemgrep.String())
+	// #nosec G204, UnpackedPath and the configs do not contain user controllable data
+	cmdSemgrep := exec.CommandContext(ctx, "semgrep", args...)
+	cmdSemgrep.Dir = s.rulesPath()
+	setProcessGroup(cmdSemgrep)
+	cmdSemgrep.WaitDelay = waitDelay
+	s.log().Info("Running %s", cmdSemgrep.String())
 	out, err := cmdSemgrep.Output()
 	if err != nil {
 		return err
 	}
 
+	if err := s.setStage(db, StageParsing); err != nil {
+		return err
+	}
+
 	// Unmarshall the output into a []Result struct
 	// to verify the JSON
 	semgrepResults := semgrepResults{}
@@ -82,12 +139,59 @@ func (s *Scan) runSemgrep() (err error) {
 	// Store the JSON, as sqlite does not have support for arrays
 	s.SemgrepOutput = outStr
 
+	sources, err := json.Marshal(s.ruleSources(semgrepResults.Results, ruleIDs))
+	if err != nil {
+		return err
+	}
+	s.RuleSources = string(sources)
+
+	// Stored by finish together with the scan
+	if err := s.buildFindings(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// unpack unpacks the file based on the file extension
-func (s *Scan) unpack() (err error) {
-	logger.Info("Unpacking %s", s.UploadPath)
+// ruleSources returns the rulesets each rule ID of the results came from. Results of rules that are not part of
+// a ruleset with known rule IDs, like the registry rulesets, are attributed to all rulesets with unknown rule IDs
+func (s *Scan) ruleSources(results []Result, ruleIDs map[string][]string) (sources map[string][]string) {
+	var unknown []string
+	for _, ruleset := range s.Rulesets {
+		if ruleIDs[ruleset.Name] == nil {
+			unknown = append(unknown, ruleset.Name)
+		}
+	}
+
+	sources = map[string][]string{}
+	for _, result := range results {
+		if _, ok := sources[result.CheckID]; ok {
+			continue
+		}
+
+		var rulesets []string
+		for _, ruleset := range s.Rulesets {
+			for _, id := range ruleIDs[ruleset.Name] {
+				// Semgrep prefixes the rule IDs of local configs with their directory
+				if result.CheckID == id || strings.HasSuffix(result.CheckID, "."+id) {
+					rulesets = append(rulesets, ruleset.Name)
+					break
+				}
+			}
+		}
+		if rulesets == nil {
+			rulesets = unknown
+		}
+
+		sources[result.CheckID] = rulesets
+	}
+
+	return sources
+}
+
+// unpack unpacks the file based on the file extension, stops early once ctx is done
+func (s *Scan) unpack(ctx context.Context) (err error) {
+	s.log().Info("Unpacking %s", s.UploadPath)
 
 	mtype, err := mimetype.DetectFile(s.UploadPath)
 	if err != nil {
@@ -95,54 +199,84 @@ func (s *Scan) unpack() (err error) {
 	}
 	extension := mtype.Extension()
 
-	// Create the output directory first as tar does not create it
-	// #nosec G204, UnpackedPath does not contain user controllable data
-	cmdMkdir := exec.Command("mkdir", "-p", s.UnpackedPath)
-	if err := cmdMkdir.Run(); err != nil {
+	e, err := newExtractor(ctx, s.UploadPath, s.UnpackedPath)
+	if err != nil {
 		return err
 	}
 
-	var cmdUnpack *exec.Cmd
 	switch extension {
 	case ".zip":
-		// #nosec G204, UploadPath and UnpackedPath do not contain user controllable data
-		cmdUnpack = exec.Command("unzip", s.UploadPath, "-d", s.UnpackedPath)
+		err = e.extractZip(s.UploadPath)
+	case ".7z":
+		err = e.extract7z(s.UploadPath)
 	case ".tar":
-		// #nosec G204, UploadPath and UnpackedPath do not contain user controllable data
-		cmdUnpack = exec.Command("tar", "-xf", s.UploadPath, "--directory", s.UnpackedPath)
+		err = e.extractCompressed(s.UploadPath, s.UploadName, nil)
 	case ".gz":
-		// #nosec G204, UploadPath and UnpackedPath do not contain user controllable data
-		cmdUnpack = exec.Command("tar", "-xzf", s.UploadPath, "--directory", s.UnpackedPath)
+		err = e.extractCompressed(s.UploadPath, s.UploadName, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) })
 	case ".bz2":
-		// #nosec G204, UploadPath and UnpackedPath do not contain user controllable data
-		cmdUnpack = exec.Command("tar", "-xjf", s.UploadPath, "--directory", s.UnpackedPath)
+		err = e.extractCompressed(s.UploadPath, s.UploadName, func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil })
+	case ".xz":
+		err = e.extractCompressed(s.UploadPath, s.UploadName, func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) })
+	case ".zst":
+		err = e.extractCompressed(s.UploadPath, s.UploadName, func(r io.Reader) (io.Reader, error) {
+			decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		})
 	default:
 		// Technically not reachable
 		return fmt.Errorf("unsupported file extension %s", extension)
 	}
+	if err != nil {
+		return err
+	}
 
-	logger.Info("Running %s", cmdUnpack.String())
-	if err := cmdUnpack.Run(); err != nil {
+	if err := e.verifySymlinks(); err != nil {
 		return err
 	}
 
+	s.log().Info("Unpacked %d files (%d bytes) from %s", e.files, e.written, s.UploadPath)
 	return nil
 }
 
 // cleanup removes the original file and the unpacked directory.
 // Do not return any errors as the cleanup should not fail the scan
 func (s *Scan) cleanup() {
-	// Remove the original file
-	logger.Info("Removing %s", s.UploadPath)
-	cmdRmOg := exec.Command("rm", s.UploadPath) // #nosec G204, UploadPath does not contain user controllable data
-	if err := cmdRmOg.Run(); err != nil {
-		logger.ErrorF("error removing file %s: %s", s.UploadPath, err)
+	// Remove the original file, scans of Git repositories do not have one
+	if s.UploadPath != "" {
+		s.log().Debug("Removing %s", s.UploadPath)
+		cmdRmOg := exec.Command("rm", s.UploadPath) // #nosec G204, UploadPath does not contain user controllable data
+		if err := cmdRmOg.Run(); err != nil {
+			s.log().ErrorF("error removing file %s: %s", s.UploadPath, err)
+		}
+	}
+
+	s.removeDirs()
+}
+
+// removeDirs removes the unpacked directory and the custom rules of the scan
+func (s *Scan) removeDirs() {
+	for _, dir := range []string{s.UnpackedPath, s.rulesPath()} {
+		s.log().Debug("Removing %s", dir)
+		cmdRmUnpacked := exec.Command("rm", "-rf", dir) // #nosec G204, the directories do not contain user controllable data
+		if err := cmdRmUnpacked.Run(); err != nil {
+			s.log().ErrorF("error removing directory %s: %s", dir, err)
+		}
 	}
+}
 
-	// Remove the unpacked directory
-	logger.Info("Removing %s", s.UnpackedPath)
-	cmdRmUnpacked := exec.Command("rm", "-rf", s.UnpackedPath) // #nosec G204, UnpackedPath does not contain user controllable data
-	if err := cmdRmUnpacked.Run(); err != nil {
-		logger.ErrorF("error removing unpacked directory %s: %s", s.UnpackedPath, err)
+// log returns a logger adding the scan ID and the ID of the request that created the scan to every log line
+func (s *Scan) log() *logger.Logger {
+	if s.RequestID == "" {
+		return logger.With("scan_id", s.ID.String())
 	}
+
+	return logger.With("scan_id", s.ID.String(), "request_id", s.RequestID)
+}
+
+// rulesPath returns the directory the custom rules of the scan are written to
+func (s *Scan) rulesPath() string {
+	return s.UnpackedPath + "-rules"
 }
@@ -0,0 +1,161 @@
+package semgrep
+
+import (
+	"bagel/internal/logger"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The triage status of a finding, decided by a reviewer
+const (
+	TriageUntriaged     = "untriaged"      // Not reviewed yet
+	TriageConfirmed     = "confirmed"      // A real issue that needs to be fixed
+	TriageFalsePositive = "false_positive" // Not an issue
+	TriageWontFix       = "wont_fix"       // A real issue whose risk is accepted
+	TriageFixed         = "fixed"          // A real issue that was fixed
+)
+
+const (
+	// Maximum length of a triage comment
+	MaxTriageCommentLength = 4096
+)
+
+var (
+	// All statuses a finding can be triaged as
+	TriageStatuses = []string{TriageUntriaged, TriageConfirmed, TriageFalsePositive, TriageWontFix, TriageFixed}
+
+	// The names of the triage statuses shown to users
+	triageLabels = map[string]string{
+		TriageUntriaged:     "Untriaged",
+		TriageConfirmed:     "Confirmed",
+		TriageFalsePositive: "False positive",
+		TriageWontFix:       "Won't fix",
+		TriageFixed:         "Fixed",
+	}
+
+	// Decisions that are carried forward to the same finding in later scans of the same codebase.
+	// Confirmed and fixed findings that show up again need to be looked at again
+	carriedTriageStatuses = []string{TriageFalsePositive, TriageWontFix}
+
+	// The statuses of findings that need no further action, all other findings are open
+	closedTriageStatuses = []string{TriageFalsePositive, TriageWontFix, TriageFixed}
+
+	ErrFindingNotFound     = errors.New("finding not found")
+	ErrInvalidTriageStatus = errors.New("invalid triage status")
+	ErrTriageCommentLength = fmt.Errorf("comment cannot be longer than %d characters", MaxTriageCommentLength)
+)
+
+// TriageLabel returns the name of a triage status shown to users
+func TriageLabel(status string) string {
+	if label, ok := triageLabels[status]; ok {
+		return label
+	}
+
+	return triageLabels[TriageUntriaged]
+}
+
+// TriageLabel returns the name of the triage status of the finding shown to users
+func (f *Finding) TriageLabel() string {
+	return TriageLabel(f.TriageStatus)
+}
+
+// TriageCarried reports if the decision was made for the same finding in an earlier scan
+func (f *Finding) TriageCarried() bool {
+	return f.TriageScanID != uuid.Nil && f.TriageScanID != f.ScanID
+}
+
+// TriageOpen reports if the finding still needs action, so it was not triaged as a false positive, won't fix or fixed
+func (f *Finding) TriageOpen() bool {
+	return !slices.Contains(closedTriageStatuses, f.TriageStatus)
+}
+
+// Triage stores the decision of a reviewer for a finding of the given scan
+func Triage(db *gorm.DB, scanID uuid.UUID, findingID uuid.UUID, status string, comment string, username string) (finding *Finding, err error) {
+	if !slices.Contains(TriageStatuses, status) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTriageStatus, status)
+	}
+	if len(comment) > MaxTriageCommentLength {
+		return nil, ErrTriageCommentLength
+	}
+
+	result := db.Model(&Finding{}).
+		Where("id = ? AND scan_id = ?", findingID, scanID).
+		Updates(map[string]interface{}{
+			"triage_status":  status,
+			"triage_comment": comment,
+			"triaged_by":     username,
+			"triaged_at":     time.Now(),
+			"triage_scan_id": scanID,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrFindingNotFound
+	}
+	logger.With("scan_id", scanID.String(), "finding_id", findingID.String()).Info("Finding was triaged as %s by %s", status, username)
+
+	finding = &Finding{}
+	if err := db.First(finding, "id = ?", findingID).Error; err != nil {
+		return nil, err
+	}
+
+	return finding, nil
+}
+
+// carryTriage applies the decisions made for the same findings in earlier scans of the same codebase, recognized
+// by the project or, for scans without a project, by the upload name or Git URL. Findings are matched by the
+// fingerprint. Only the latest decision per fingerprint counts, so a finding that was triaged again as confirmed
+// later on is not carried forward as a false positive anymore
+func (s *Scan) carryTriage(db *gorm.DB) (err error) {
+	if len(s.Findings) == 0 {
+		return nil
+	}
+
+	var previous []Finding
+	err = s.sameCodebase(db.Model(&Finding{})).
+		Select("findings.scan_id, findings.fingerprint, findings.triage_status, findings.triage_comment, findings.triaged_by, findings.triaged_at, findings.triage_scan_id").
+		Joins("JOIN scans ON scans.id = findings.scan_id").
+		Where("scans.id != ? AND findings.triaged_by != ''", s.ID).
+		Order("findings.triaged_at desc").
+		Find(&previous).Error
+	if err != nil {
+		return err
+	}
+
+	decisions := map[string]*Finding{}
+	for i := range previous {
+		if _, ok := decisions[previous[i].Fingerprint]; !ok {
+			decisions[previous[i].Fingerprint] = &previous[i]
+		}
+	}
+
+	carried := 0
+	for i := range s.Findings {
+		f := &s.Findings[i]
+		decision, ok := decisions[f.Fingerprint]
+		if !ok || !slices.Contains(carriedTriageStatuses, decision.TriageStatus) {
+			continue
+		}
+
+		f.TriageStatus = decision.TriageStatus
+		f.TriageComment = decision.TriageComment
+		f.TriagedBy = decision.TriagedBy
+		f.TriagedAt = decision.TriagedAt
+		f.TriageScanID = decision.TriageScanID
+		if f.TriageScanID == uuid.Nil {
+			f.TriageScanID = decision.ScanID
+		}
+		carried++
+	}
+	if carried > 0 {
+		s.log().Info("Carried %d triage decisions forward", carried)
+	}
+
+	return nil
+}
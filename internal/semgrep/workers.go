@@ -1,42 +1,77 @@
 package semgrep
 
 import (
+	"bagel/internal/config"
 	"bagel/internal/logger"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 var (
-	chanJobs chan *Scan
-	chanStop chan bool
+	chanJobs chan struct{} // Notifies idle workers about new scans in the queue
+	chanStop chan struct{} // Closed to stop all workers
 	wgJobs   *sync.WaitGroup
+
+	runningWorkers atomic.Int32 // Number of worker goroutines that are started and not stopped yet
+
+	workerCount      = max(config.Int("BAGEL_WORKERS", 3), 1)
+	scanTimeout      = config.Duration("BAGEL_SCAN_TIMEOUT", time.Hour) // Wall-clock limit for a whole scan, 0 to disable
+	semgrepTimeout   = config.Int("BAGEL_SEMGREP_TIMEOUT", 5)           // Passed to --timeout, seconds per rule and file
+	semgrepMaxMemory = config.Int("BAGEL_SEMGREP_MAX_MEMORY", 0)        // Passed to --max-memory, MB per file, 0 for no limit
+
+	// Cancel functions of the scans currently running, by scan ID
+	runningJobs   = map[uuid.UUID]context.CancelCauseFunc{}
+	runningJobsMu sync.Mutex
+
+	ErrScanTimeout    = errors.New("scan timed out")
+	ErrScanCancelled  = errors.New("scan was cancelled")
+	ErrWorkersStopped = errors.New("workers were stopped")
 )
 
 const (
-	workerCount = 3 // Hardcoded to 3 workers for now
+	pollInterval = 10 * time.Second // How often idle workers check the queue without being notified
 )
 
 // checkIfInstalled checks if Semgrep is installed
 func checkIfInstalled() bool {
 	logger.Info("Checking if Semgrep is installed")
 
-	cmdHelp := exec.Command("semgrep", "--help")
+	if err := CheckRunnable(context.Background()); err != nil {
+		return false
+	}
+
+	logger.Info("Semgrep is installed")
+	return true
+}
+
+// CheckRunnable checks if Semgrep is in $PATH and can be run, it is killed once ctx is done
+func CheckRunnable(ctx context.Context) (err error) {
+	cmdHelp := exec.CommandContext(ctx, "semgrep", "--help")
 	out, err := cmdHelp.Output()
 	if err != nil {
-		return false
+		return err
 	}
 
 	if !strings.Contains(string(out), "Usage: semgrep") {
-		return false
+		return errors.New("unexpected output of semgrep --help")
 	}
 
-	logger.Info("Semgrep is installed")
-	return true
+	return nil
+}
+
+// Workers returns the number of running workers and the number of workers that should be running
+func Workers() (running int, configured int) {
+	return int(runningWorkers.Load()), workerCount
 }
 
 // checkForPro checks if an ENV variable is present to use Semgrep Pro
@@ -65,7 +100,7 @@ func checkForPro() (err error) {
 	return nil
 }
 
-// StartWorkers starts the worker goroutines
+// StartWorkers recovers interrupted scans and starts the worker goroutines
 func StartWorkers(db *gorm.DB) (err error) {
 	if ok := checkIfInstalled(); !ok {
 		return fmt.Errorf("semgrep is not installed or in $PATH")
@@ -75,15 +110,29 @@ func StartWorkers(db *gorm.DB) (err error) {
 		return err
 	}
 
-	chanJobs = make(chan *Scan)
-	chanStop = make(chan bool, workerCount)
+	if err := recoverJobs(db); err != nil {
+		return fmt.Errorf("error recovering interrupted scans: %s", err)
+	}
+
+	registerQueueMetrics(db)
+
+	chanJobs = make(chan struct{}, workerCount)
+	chanStop = make(chan struct{})
 	wgJobs = new(sync.WaitGroup)
 
 	for i := 0; i < workerCount; i++ {
+		wgJobs.Add(1)
+
 		go func() {
 			defer wgJobs.Done()
 			logger.Info("Starting worker %d", i)
 
+			runningWorkers.Add(1)
+			defer runningWorkers.Add(-1)
+
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+
 			for {
 				select {
 				case <-chanStop:
@@ -91,46 +140,121 @@ func StartWorkers(db *gorm.DB) (err error) {
 					logger.Info("Stopping worker %d", i)
 					return
 
-				case job := <-chanJobs:
-					logger.Info("Starting scan %s", job.ID.String())
+				case <-chanJobs:
+				case <-ticker.C:
+				}
 
-					// Run the scan
-					if err := job.runSemgrep(); err != nil {
-						errMsg := fmt.Errorf("error running scan %s: %s", job.ID.String(), err)
-						job.Error = errMsg.Error()
-						logger.Error(errMsg)
+				// Work through the queue until it is empty or the workers are stopped
+				for {
+					select {
+					case <-chanStop:
+						logger.Info("Stopping worker %d", i)
+						return
+					default:
 					}
 
-					job.Finished = true
-
-					// Save the scan to the database
-					if err := db.Save(job).Error; err != nil {
-						logger.ErrorF("error saving scan %s: %s", job.ID.String(), err)
+					job, err := claimJob(db)
+					if err != nil {
+						logger.ErrorF("error claiming scan: %s", err)
+						break
+					}
+					if job == nil {
+						break
 					}
 
-					logger.Info("Finished scan %s", job.ID.String())
+					runJob(db, job)
 				}
 			}
 		}()
-
-		wgJobs.Add(1)
 	}
 
+	// Start with any scans that are still queued
+	notifyWorkers()
+
 	return nil
 }
 
-// StopWorkers stops the worker goroutines
-func StopWorkers() {
-	logger.Info("Stopping workers")
+// runJob runs a claimed scan and stores the result in the database
+func runJob(db *gorm.DB, job *Scan) {
+	log := job.log()
+	log.Info("Starting scan")
+
+	metricWorkers.Add(1, "busy")
+	metricWorkers.Add(-1, "idle")
+	defer func() {
+		metricWorkers.Add(-1, "busy")
+		metricWorkers.Add(1, "idle")
+	}()
+
+	// Register the scan so it can be cancelled while running
+	ctx, cancelCause := context.WithCancelCause(context.Background())
+	runningJobsMu.Lock()
+	runningJobs[job.ID] = cancelCause
+	runningJobsMu.Unlock()
+
+	// Claimed while the workers were being stopped, after the running scans were cancelled
+	select {
+	case <-chanStop:
+		cancelCause(ErrWorkersStopped)
+	default:
+	}
+
+	defer func() {
+		runningJobsMu.Lock()
+		delete(runningJobs, job.ID)
+		runningJobsMu.Unlock()
+		cancelCause(nil)
+	}()
 
-	// Send the stop signal
-	for i := 0; i <= workerCount; i++ {
-		chanStop <- true
+	cancel := context.CancelFunc(func() {})
+	if scanTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, scanTimeout)
 	}
+	defer cancel()
 
-	close(chanJobs)
+	// Run the scan
+	errScan := job.runSemgrep(ctx, db)
+	if errScan != nil && errors.Is(context.Cause(ctx), ErrWorkersStopped) {
+		log.Info("Scan was interrupted by stopping the workers, adding it to the queue again")
+		if err := job.requeue(db); err != nil {
+			log.ErrorF("error adding scan %s to the queue again: %s", job.ID.String(), err)
+		}
+		return
+	}
+	if errors.Is(context.Cause(ctx), ErrScanCancelled) {
+		log.Info("Cancelled scan")
+		errScan = ErrScanCancelled
+	} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		errScan = fmt.Errorf("%w after %s", ErrScanTimeout, scanTimeout)
+	}
+	if errScan != nil && errScan != ErrScanCancelled {
+		errScan = fmt.Errorf("error running scan %s: %s", job.ID.String(), errScan)
+		log.Error(errScan)
+	}
+
+	// Save the scan to the database
+	if err := job.finish(db, errScan); err != nil {
+		log.ErrorF("error saving scan %s: %s", job.ID.String(), err)
+	}
+
+	log.Info("Finished scan")
+}
+
+// StopWorkers stops the worker goroutines. Running scans are cancelled and added to the queue again,
+// scans that are queued stay in the database and are picked up on the next start
+func StopWorkers() {
+	logger.Info("Stopping workers")
+
+	// Send the stop signal to all workers
 	close(chanStop)
 
+	// Do not wait for running scans, which can take up to BAGEL_SCAN_TIMEOUT
+	runningJobsMu.Lock()
+	for _, cancelCause := range runningJobs {
+		cancelCause(ErrWorkersStopped)
+	}
+	runningJobsMu.Unlock()
+
 	// Wait for all workers to finish stopping
 	wgJobs.Wait()
 
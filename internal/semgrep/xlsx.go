@@ -0,0 +1,137 @@
+package semgrep
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Excel rejects cells with more characters
+	xlsxMaxCellLength = 32767
+
+	// Columns are sized to their content up to this width in characters
+	xlsxMaxColumnWidth = 80
+)
+
+// The static parts of a workbook with a single worksheet. The styles are the default, a bold header and wrapped text
+var xlsxStaticFiles = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0" applyAlignment="1"><alignment vertical="top" wrapText="1"/></xf></cellXfs><cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles></styleSheet>`},
+}
+
+// writeXLSX writes a workbook with a single worksheet of strings, with a frozen and filterable header row
+func writeXLSX(w io.Writer, sheet string, header []string, rows [][]string) error {
+	archive := zip.NewWriter(w)
+
+	for _, file := range xlsxStaticFiles {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := archive.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`, xlsxEscape(sheet)); err != nil {
+		return err
+	}
+
+	f, err = archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, xlsxWorksheet(header, rows)); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// xlsxWorksheet returns the XML of a worksheet with the header in bold and the rows with wrapped text
+func xlsxWorksheet(header []string, rows [][]string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+
+	// Size the columns to the longest line of their values
+	b.WriteString("<cols>")
+	for i := range header {
+		width := utf8.RuneCountInString(header[i])
+		for _, row := range rows {
+			if i < len(row) {
+				for _, line := range strings.Split(row[i], "\n") {
+					width = max(width, utf8.RuneCountInString(line))
+				}
+			}
+		}
+		fmt.Fprintf(&b, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, min(width, xlsxMaxColumnWidth)+2)
+	}
+	b.WriteString("</cols>")
+
+	b.WriteString("<sheetData>")
+	xlsxRow(&b, 1, header, 1)
+	for i, row := range rows {
+		xlsxRow(&b, i+2, row, 2)
+	}
+	b.WriteString("</sheetData>")
+
+	fmt.Fprintf(&b, `<autoFilter ref="A1:%s"/>`, xlsxCellReference(len(header)-1, len(rows)+1))
+	b.WriteString("</worksheet>")
+
+	return b.String()
+}
+
+// xlsxRow writes a row of inline strings with the given style
+func xlsxRow(b *strings.Builder, number int, values []string, style int) {
+	fmt.Fprintf(b, `<row r="%d">`, number)
+	for i, value := range values {
+		if len(value) > xlsxMaxCellLength {
+			value = strings.ToValidUTF8(value[:xlsxMaxCellLength], "")
+		}
+		fmt.Fprintf(b, `<c r="%s" t="inlineStr" s="%d"><is><t xml:space="preserve">%s</t></is></c>`, xlsxCellReference(i, number), style, xlsxEscape(value))
+	}
+	b.WriteString("</row>")
+}
+
+// xlsxColumn returns the name of the column at the zero-based index, like A, Z or AA
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+
+	return name
+}
+
+// xlsxEscape escapes a string for XML text and attributes, characters that are invalid in XML are replaced
+func xlsxEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+
+	// EscapeText escapes newlines, which Excel shows as line breaks either way
+	return strings.ReplaceAll(b.String(), "&#xA;", "\n")
+}
+
+// xlsxCellReference returns the reference of a cell, like B3, for a zero-based column and a one-based row
+func xlsxCellReference(column int, row int) string {
+	return xlsxColumn(column) + strconv.Itoa(row)
+}
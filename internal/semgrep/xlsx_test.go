@@ -0,0 +1,176 @@
+package semgrep
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// xlsxSheet is the part of a worksheet checked by the tests
+type xlsxSheet struct {
+	Rows []struct {
+		R     string `xml:"r,attr"`
+		Cells []struct {
+			R     string `xml:"r,attr"`
+			Type  string `xml:"t,attr"`
+			Style string `xml:"s,attr"`
+			Text  string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+	AutoFilter struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"autoFilter"`
+}
+
+// readXLSX opens a workbook as a zip file and returns its files, every XML file has to be well-formed
+func readXLSX(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = content
+
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %s", file.Name, err)
+			}
+		}
+	}
+
+	return files
+}
+
+// TestWriteXLSX checks the files of the workbook and that the cells hold the values, including special characters
+func TestWriteXLSX(t *testing.T) {
+	header := []string{"Rule ID", "Message"}
+	rows := [][]string{
+		{"html.xss", `<script>alert("x") & 'y'</script>`},
+		{"]]>", "first line\nsecond line"},
+		{"control", "bell\x07 and \x00null"},
+		{"long", strings.Repeat("é", xlsxMaxCellLength)},
+	}
+
+	var b bytes.Buffer
+	if err := writeXLSX(&b, `Findings <&> "1"`, header, rows); err != nil {
+		t.Fatal(err)
+	}
+	files := readXLSX(t, b.Bytes())
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/workbook.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("expected %s in the workbook", name)
+		}
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(files["xl/workbook.xml"], &workbook); err != nil {
+		t.Fatal(err)
+	}
+	if len(workbook.Sheets) != 1 || workbook.Sheets[0].Name != `Findings <&> "1"` {
+		t.Errorf("expected a single sheet with the escaped name, got %+v", workbook.Sheets)
+	}
+
+	var sheet xlsxSheet
+	if err := xml.Unmarshal(files["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append([][]string{header}, rows...)
+	// Characters that are invalid in XML are replaced and cells are cut to the length Excel accepts
+	expected[3] = []string{"control", "bell� and �null"}
+	expected[4] = []string{"long", strings.Repeat("é", xlsxMaxCellLength/2)}
+
+	if len(sheet.Rows) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(sheet.Rows))
+	}
+	references := [][]string{{"A1", "B1"}, {"A2", "B2"}, {"A3", "B3"}, {"A4", "B4"}, {"A5", "B5"}}
+	for i, row := range sheet.Rows {
+		var values, cells []string
+		for _, cell := range row.Cells {
+			values = append(values, cell.Text)
+			cells = append(cells, cell.R)
+			if cell.Type != "inlineStr" {
+				t.Errorf("cell %s: expected an inline string, got %s", cell.R, cell.Type)
+			}
+			if style := map[bool]string{true: "1", false: "2"}[i == 0]; cell.Style != style {
+				t.Errorf("cell %s: expected style %s, got %s", cell.R, style, cell.Style)
+			}
+		}
+		if !slices.Equal(values, expected[i]) {
+			t.Errorf("row %s: expected %q, got %q", row.R, expected[i], values)
+		}
+		if !slices.Equal(cells, references[i]) {
+			t.Errorf("row %s: expected cells %v, got %v", row.R, references[i], cells)
+		}
+	}
+
+	if sheet.AutoFilter.Ref != "A1:B5" {
+		t.Errorf("expected the filter on A1:B5, got %s", sheet.AutoFilter.Ref)
+	}
+}
+
+// TestScanWriteXLSX checks that the export of a scan has the header and a row per finding
+func TestScanWriteXLSX(t *testing.T) {
+	s := Scan{Findings: []Finding{
+		{RuleID: "python.lang.security.audit.eval", Path: "app/main.py", Severity: "ERROR", Message: "Detected eval(<input>)", Lines: "eval(data)"},
+		{RuleID: "python.flask.security.xss", Path: "app/views.py", Severity: "WARNING", Lines: "=cmd|' /C calc'!A0"},
+	}}
+
+	var b bytes.Buffer
+	if err := s.WriteXLSX(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	var sheet xlsxSheet
+	if err := xml.Unmarshal(readXLSX(t, b.Bytes())["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+	if len(sheet.Rows) != 1+len(s.Findings) {
+		t.Fatalf("expected %d rows, got %d", 1+len(s.Findings), len(sheet.Rows))
+	}
+
+	expected := append([][]string{ExportColumns}, s.Findings[0].ExportRow(), s.Findings[1].ExportRow())
+	for i, row := range sheet.Rows {
+		var values []string
+		for _, cell := range row.Cells {
+			values = append(values, cell.Text)
+		}
+		if !slices.Equal(values, expected[i]) {
+			t.Errorf("row %s: expected %q, got %q", row.R, expected[i], values)
+		}
+	}
+}
+
+// TestXLSXColumn checks the names of columns past Z
+func TestXLSXColumn(t *testing.T) {
+	for index, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(index); got != expected {
+			t.Errorf("xlsxColumn(%d): expected %s, got %s", index, expected, got)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package webhook
+
+import (
+	"bagel/internal/config"
+	"bagel/internal/logger"
+	"bagel/internal/random"
+	"bagel/internal/semgrep"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The events a webhook receives
+const (
+	EventScanFinished = "scan.finished" // A scan is done, failed or was cancelled
+	EventTest         = "test"          // Sent on demand to check the endpoint
+)
+
+// The status of a delivery
+const (
+	DeliveryPending   = "pending"   // Waiting for its first or next attempt
+	DeliverySucceeded = "succeeded" // The endpoint responded with a 2xx status
+	DeliveryFailed    = "failed"    // All attempts failed
+)
+
+const (
+	// The header carrying the hex HMAC-SHA256 of the body, keyed with the secret of the webhook
+	SignatureHeader = "X-Bagel-Signature-256"
+
+	// Maximum number of bytes of a response body stored in the delivery log
+	maxResponseBody = 1024
+
+	// Number of deliveries sent per run of the dispatcher
+	dispatchBatchSize = 20
+
+	// How often the dispatcher checks for deliveries that are due without being notified
+	dispatchInterval = 10 * time.Second
+
+	// Upper limit of the delay between two attempts, and of the number of times the retry delay is doubled
+	maxRetryDelay = time.Hour
+	maxRetryShift = 16
+)
+
+var (
+	maxAttempts = max(config.Int("BAGEL_WEBHOOK_MAX_ATTEMPTS", 6), 1)
+	retryDelay  = config.Duration("BAGEL_WEBHOOK_RETRY_DELAY", 30*time.Second) // Delay before the first retry, doubled for every further retry
+
+	// Redirects are not followed, so a delivery only goes to the configured URL
+	client = &http.Client{
+		Timeout: config.Duration("BAGEL_WEBHOOK_TIMEOUT", 5*time.Second),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// The statuses of scans that are delivered to webhooks
+	finishedStatuses = []string{semgrep.StatusDone, semgrep.StatusFailed, semgrep.StatusCancelled}
+
+	chanWake chan struct{} // Notifies the dispatcher about finished scans
+	chanStop chan struct{} // Closed to stop the dispatcher
+	wgStop   *sync.WaitGroup
+
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrEmptyName       = errors.New("name cannot be empty")
+	ErrInvalidURL      = errors.New("URL must be an absolute http or https URL")
+)
+
+// Webhook is an endpoint that is notified when scans finish
+type Webhook struct {
+	ID        uuid.UUID `gorm:"type:text;primaryKey;"` // The UUID of the webhook
+	Name      string    `gorm:"type:text"`             // The name of the webhook defined by the user
+	URL       string    `gorm:"type:text"`             // The URL the payloads are posted to
+	Secret    string    `gorm:"type:text"`             // The key of the HMAC signature, the receiver needs it to verify payloads
+	CreatedAt time.Time // The timestamp the webhook was created
+}
+
+// Delivery is a payload sent or to be sent to a webhook, together with the result of the last attempt
+type Delivery struct {
+	ID             uuid.UUID `gorm:"type:text;primaryKey;"` // The UUID of the delivery, sent in the X-Bagel-Delivery header
+	WebhookID      uuid.UUID `gorm:"type:text;index"`       // The webhook the delivery is sent to
+	ScanID         uuid.UUID `gorm:"type:text;index"`       // The scan the delivery is about, nil for test events
+	Event          string    `gorm:"type:text"`             // One of the Event constants
+	Payload        string    `gorm:"type:text"`             // The JSON body, signed as is
+	Status         string    `gorm:"type:text;index"`       // One of the Delivery constants
+	Attempts       int       // The number of attempts so far
+	NextAttemptAt  time.Time `gorm:"index"` // The timestamp of the next attempt of pending deliveries
+	ResponseStatus int       // The HTTP status code of the last attempt, 0 if there was no response
+	ResponseBody   string    `gorm:"type:text"` // The start of the response body of the last attempt
+	Error          string    `gorm:"type:text"` // The error of the last attempt
+	CreatedAt      time.Time // The timestamp the delivery was created
+	DeliveredAt    time.Time // The timestamp of the last attempt
+}
+
+// Payload is the JSON body posted to webhooks
+type Payload struct {
+	Event     string       `json:"event"`
+	CreatedAt time.Time    `json:"created_at"`
+	Scan      *PayloadScan `json:"scan,omitempty"` // Not set for test events
+}
+
+// PayloadScan is the finished scan a payload is about
+type PayloadScan struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	ProjectID  string          `json:"project_id,omitempty"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Findings   PayloadFindings `json:"findings"`
+}
+
+// PayloadFindings are the counts of the findings of a scan
+type PayloadFindings struct {
+	Total      int            `json:"total"`
+	Open       int            `json:"open"`
+	BySeverity map[string]int `json:"by_severity"`
+}
+
+// Models returns the models of this package for the database migration
+func Models() []interface{} {
+	return []interface{}{&Webhook{}, &Delivery{}}
+}
+
+// Create adds a webhook. A random secret is generated if none is given
+func Create(db *gorm.DB, name string, rawURL string, secret string) (webhook *Webhook, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	if secret == "" {
+		if secret, err = random.String(32); err != nil {
+			return nil, err
+		}
+	}
+
+	webhook = &Webhook{ID: random.UUID(), Name: name, URL: u.String(), Secret: secret}
+	if err := db.Create(webhook).Error; err != nil {
+		return nil, err
+	}
+
+	logger.Info("Created webhook %s for %s", webhook.ID.String(), webhook.URL)
+	return webhook, nil
+}
+
+// List returns all webhooks ordered by name
+func List(db *gorm.DB) (webhooks []Webhook, err error) {
+	err = db.Order("name").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// Find loads the webhook with the given ID
+func Find(db *gorm.DB, id uuid.UUID) (webhook *Webhook, err error) {
+	webhook = &Webhook{}
+	result := db.Limit(1).Find(webhook, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrWebhookNotFound
+	}
+
+	return webhook, nil
+}
+
+// Delete removes a webhook together with its delivery log
+func Delete(db *gorm.DB, id uuid.UUID) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&Webhook{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrWebhookNotFound
+		}
+
+		return tx.Delete(&Delivery{}, "webhook_id = ?", id).Error
+	})
+}
+
+// Deliveries returns up to limit deliveries of the webhook, newest first
+func (w *Webhook) Deliveries(db *gorm.DB, limit int) (deliveries []Delivery, err error) {
+	err = db.Where("webhook_id = ?", w.ID).Order("created_at desc").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// SendTest sends a test event to the webhook right away. It is attempted once and logged like any other delivery
+func (w *Webhook) SendTest(db *gorm.DB) (delivery *Delivery, err error) {
+	delivery, err = newDelivery(w, uuid.Nil, &Payload{Event: EventTest, CreatedAt: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored after the attempt, so the dispatcher never picks it up as pending
+	w.send(delivery)
+	delivery.Status = DeliverySucceeded
+	if delivery.Error != "" {
+		delivery.Status = DeliveryFailed
+	}
+	if err := db.Create(delivery).Error; err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// Start delivers the payloads of scans that finished since a webhook was created. The deliveries are created from
+// the database, so scans finished while Bagel was stopped or whose events were dropped are delivered as well
+func Start(db *gorm.DB) {
+	chanWake = make(chan struct{}, 1)
+	chanStop = make(chan struct{})
+	wgStop = new(sync.WaitGroup)
+
+	events, unsubscribe := semgrep.SubscribeEvents(uuid.Nil)
+
+	// Finished scans only wake up the dispatcher, which finds them in the database
+	wgStop.Add(1)
+	go func() {
+		defer wgStop.Done()
+		defer unsubscribe()
+
+		for {
+			select {
+			case <-chanStop:
+				return
+			case event := <-events:
+				if event.Finished() {
+					wake()
+				}
+			}
+		}
+	}()
+
+	// Create and send the deliveries that are due
+	wgStop.Add(1)
+	go func() {
+		defer wgStop.Done()
+
+		for {
+			if err := sweep(db); err != nil {
+				logger.ErrorF("error creating webhook deliveries: %s", err)
+			}
+			if err := dispatch(db); err != nil {
+				logger.ErrorF("error sending webhook deliveries: %s", err)
+			}
+
+			// Sleep until the next retry is due, but check regularly in case the database was changed otherwise
+			wait := dispatchInterval
+			if next, err := nextAttempt(db); err == nil && !next.IsZero() {
+				wait = max(min(wait, time.Until(next)), 0)
+			}
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-chanStop:
+				timer.Stop()
+				return
+			case <-chanWake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Stop stops delivering payloads after the current attempt. Pending deliveries are sent on the next start
+func Stop() {
+	close(chanStop)
+	wgStop.Wait()
+}
+
+// wake notifies the dispatcher without blocking, a notification that is already pending covers this one
+func wake() {
+	select {
+	case chanWake <- struct{}{}:
+	default:
+	}
+}
+
+// sweep creates a delivery for every scan that finished since a webhook was created and has none for it yet.
+// It only runs in the dispatcher, so no delivery is created twice
+func sweep(db *gorm.DB) (err error) {
+	webhooks, err := List(db)
+	if err != nil {
+		return err
+	}
+
+	for i := range webhooks {
+		w := &webhooks[i]
+		for {
+			var scans []semgrep.Scan
+			delivered := db.Model(&Delivery{}).Select("1").
+				Where("deliveries.scan_id = scans.id AND deliveries.webhook_id = ? AND deliveries.event = ?", w.ID, EventScanFinished)
+			err := db.Omit("semgrep_output").
+				Where("status IN ? AND finished_at >= ?", finishedStatuses, w.CreatedAt).
+				Where("NOT EXISTS (?)", delivered).
+				Order("finished_at").
+				Limit(dispatchBatchSize).
+				Find(&scans).Error
+			if err != nil {
+				return err
+			}
+
+			for j := range scans {
+				payload, err := newScanPayload(db, &scans[j])
+				if err != nil {
+					return err
+				}
+				delivery, err := newDelivery(w, scans[j].ID, payload)
+				if err != nil {
+					return err
+				}
+				if err := db.Create(delivery).Error; err != nil {
+					return err
+				}
+			}
+
+			if len(scans) < dispatchBatchSize {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// newScanPayload returns the payload of a finished scan
+func newScanPayload(db *gorm.DB, scan *semgrep.Scan) (payload *Payload, err error) {
+	counts, err := semgrep.CountFindings(db, scan.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload = &Payload{
+		Event:     EventScanFinished,
+		CreatedAt: time.Now(),
+		Scan: &PayloadScan{
+			ID:         scan.ID.String(),
+			Name:       scan.ScanName,
+			Status:     scan.Status,
+			Error:      scan.Error,
+			FinishedAt: scan.FinishedAt,
+			Findings:   PayloadFindings{Total: counts.Total, Open: counts.Open, BySeverity: counts.BySeverity},
+		},
+	}
+	if scan.ProjectID != uuid.Nil {
+		payload.Scan.ProjectID = scan.ProjectID.String()
+	}
+
+	return payload, nil
+}
+
+// dispatch sends the pending deliveries that are due, failed attempts are retried with exponential backoff
+func dispatch(db *gorm.DB) (err error) {
+	for {
+		var deliveries []Delivery
+		err := db.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(dispatchBatchSize).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		for i := range deliveries {
+			select {
+			case <-chanStop:
+				return nil
+			default:
+			}
+
+			d := &deliveries[i]
+			webhook, err := Find(db, d.WebhookID)
+			if errors.Is(err, ErrWebhookNotFound) {
+				// Deleted while the delivery was pending
+				d.Status = DeliveryFailed
+				d.Error = err.Error()
+			} else if err != nil {
+				return err
+			} else {
+				webhook.send(d)
+			}
+
+			switch {
+			case d.Error == "":
+				d.Status = DeliverySucceeded
+			case d.Attempts >= maxAttempts:
+				d.Status = DeliveryFailed
+				logger.Warning("Giving up on delivery %s to webhook %s after %d attempts: %s", d.ID.String(), d.WebhookID.String(), d.Attempts, d.Error)
+			default:
+				d.NextAttemptAt = time.Now().Add(backoff(d.Attempts))
+			}
+
+			if err := db.Save(d).Error; err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts, the retry delay doubled for every
+// further attempt and clamped to maxRetryDelay
+func backoff(attempts int) time.Duration {
+	shift := min(max(attempts-1, 0), maxRetryShift)
+	if retryDelay > maxRetryDelay>>shift {
+		return maxRetryDelay
+	}
+
+	return retryDelay << shift
+}
+
+// nextAttempt returns the timestamp of the next pending delivery, zero if there is none
+func nextAttempt(db *gorm.DB) (next time.Time, err error) {
+	var delivery Delivery
+	result := db.Select("next_attempt_at").Where("status = ?", DeliveryPending).Order("next_attempt_at").Limit(1).Find(&delivery)
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+
+	return delivery.NextAttemptAt, nil
+}
+
+// send posts the payload of the delivery to the webhook once and records the result in the delivery
+func (w *Webhook) send(d *Delivery) {
+	d.Attempts++
+	d.DeliveredAt = time.Now()
+	d.ResponseStatus = 0
+	d.ResponseBody = ""
+	d.Error = ""
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Bagel-Webhook")
+	req.Header.Set("X-Bagel-Event", d.Event)
+	req.Header.Set("X-Bagel-Delivery", d.ID.String())
+	req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, []byte(d.Payload)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	d.ResponseStatus = resp.StatusCode
+	d.ResponseBody = strings.ToValidUTF8(string(body), "")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		d.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of the body keyed with the secret, as sent in the signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDelivery creates a pending delivery of the payload to the webhook that is due right away
+func newDelivery(w *Webhook, scanID uuid.UUID, payload *Payload) (delivery *Delivery, err error) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(payload); err != nil {
+		return nil, err
+	}
+
+	return &Delivery{
+		ID:            random.UUID(),
+		WebhookID:     w.ID,
+		ScanID:        scanID,
+		Event:         payload.Event,
+		Payload:       strings.TrimSpace(b.String()),
+		Status:        DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}, nil
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bagel/internal/auth"
 	"bagel/internal/database"
 	"bagel/internal/logger"
 	"bagel/internal/router"
 	"bagel/internal/semgrep"
+	"bagel/internal/webhook"
 )
 
 func main() {
@@ -13,6 +15,13 @@ func main() {
 		logger.Fatal(err)
 	}
 
+	if err := auth.Init(db); err != nil {
+		logger.Fatal(err)
+	}
+
+	// Started before the workers, so scans finishing right away are delivered too
+	webhook.Start(db)
+
 	if err := semgrep.StartWorkers(db); err != nil {
 		logger.Fatal(err)
 	}
@@ -25,6 +34,7 @@ func main() {
 		}
 
 		semgrep.StopWorkers()
+		webhook.Stop()
 
 		if err := database.Close(db); err != nil {
 			logger.Fatal(err)